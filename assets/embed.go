@@ -0,0 +1,20 @@
+// Package assets contains the files compiled into the installer binary
+package assets
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed embedded-files
+var files embed.FS
+
+// Embedded returns the files from the 'embedded-files' directory
+func Embedded() fs.FS {
+	sub, err := fs.Sub(files, "embedded-files")
+	if err != nil {
+		// the directory is embedded, so this can't happen
+		panic(err)
+	}
+	return sub
+}
@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/epinio/epinio/helpers/tracelog"
+	"github.com/epinio/installer/internal/installer"
+	"github.com/epinio/installer/internal/kubernetes"
+)
+
+var CmdDiff = &cobra.Command{
+	Use:   "diff",
+	Short: "show the differences between the manifest and the cluster",
+	Long:  `compare the rendered YAML with the live objects and the helm charts and values with the deployed releases, exits non-zero if anything differs`,
+	Args:  cobra.ExactArgs(0),
+	RunE:  diff,
+}
+
+func init() {
+	CmdDiff.Flags().StringP("output", "o", "text", "output format, 'text' or 'json'")
+}
+
+func diff(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	output, err := outputFormat(cmd, "text", "json")
+	if err != nil {
+		return err
+	}
+
+	ctx := cmd.Context()
+
+	sources := newSources()
+	defer closeSources(sources)
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return err
+	}
+
+	redactor := installer.NewRedactor()
+	log := redactor.Logger(tracelog.NewLogger()).WithName("EpinioInstaller")
+	resolver := installer.NewResolver(cluster, redactor)
+
+	m, err := loadManifest(cmd)
+	if err != nil {
+		return err
+	}
+
+	// objects recorded by install are reported, if they were removed from YAML sources
+	state, err := installer.NewStateStore(cluster.Kubectl, viper.GetString("state-namespace")).Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	report := installer.NewDiffer(cluster, log, sources, resolver, state).Report(ctx, m.Components)
+
+	out := cmd.OutOrStdout()
+	if output == "json" {
+		err = report.WriteJSON(out)
+	} else {
+		err = report.WriteText(out)
+	}
+	if err != nil {
+		return err
+	}
+
+	if report.HasChanges() {
+		return errReported
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
@@ -18,21 +19,46 @@ var CmdInstall = &cobra.Command{
 	RunE:  install,
 }
 
+func init() {
+	CmdInstall.Flags().Bool("dry-run", false, "print what would be done, without changing the cluster")
+	CmdInstall.Flags().StringP("output", "o", "text", "output format, 'text' for logs only or 'json' for a stream of progress events")
+	CmdInstall.Flags().Bool("resume", false, "skip components which were installed by a previous run and did not change")
+}
+
 func install(cmd *cobra.Command, args []string) error {
 	cmd.SilenceUsage = true
-	exitfIfError(checkDependencies(), "Cannot operate")
+
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return err
+	}
+	output, err := outputFormat(cmd, "text", "json")
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		if output == "json" {
+			return errors.New("--output json is not supported with --dry-run")
+		}
+		return installDryRun(cmd)
+	}
 
 	ctx := cmd.Context()
 
+	sources := newSources()
+	defer closeSources(sources)
+
 	cluster, err := kubernetes.GetCluster(ctx)
 	if err != nil {
 		return err
 	}
 
-	log := tracelog.NewLogger().WithName("EpinioInstaller")
+	// secrets resolved from value sources are redacted from all log lines
+	redactor := installer.NewRedactor()
+	log := redactor.Logger(tracelog.NewLogger()).WithName("EpinioInstaller")
+	resolver := installer.NewResolver(cluster, redactor)
 
-	path := viper.GetString("manifest")
-	m, err := installer.Load(path)
+	m, err := loadManifest(cmd)
 	if err != nil {
 		return err
 	}
@@ -42,15 +68,50 @@ func install(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	// the hashes recorded in the state include the contents of sources and values files
+	if err := m.Components.ReadContents(ctx, sources); err != nil {
+		return err
+	}
+
 	log.Info("plan", "components", p.String())
 
-	ca := installer.NewComponentActions(cluster, log, duration.ToDeployment())
-	act := installer.NewInstall(cluster, log, ca)
+	resume, err := cmd.Flags().GetBool("resume")
+	if err != nil {
+		return err
+	}
+
+	// progress events are written to stdout, logs go to stderr
+	var events *installer.Events
+	if output == "json" {
+		events = installer.NewEvents(cmd.OutOrStdout(), redactor)
+	}
+
+	diagnostics := installer.NewDiagnostics(cluster.Kubectl, viper.GetString("diagnostics-dir"), redactor)
+	ca := installer.NewComponentActions(cluster, log, duration.ToDeployment(), diagnostics, events)
+	store := installer.NewStateStore(cluster.Kubectl, viper.GetString("state-namespace"))
+	act := installer.NewStateful(installer.NewInstall(cluster, log, ca, sources, resolver, store, viper.GetBool("atomic")), store, log, false)
+	if err := act.Prepare(ctx, m.Components, resume); err != nil {
+		return err
+	}
 
-	err = installer.Walk(ctx, m.Components, act)
+	w := &installer.Walker{Parallel: viper.GetInt("parallel"), Events: events}
+	err = w.Walk(ctx, m.Components, act)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+func installDryRun(cmd *cobra.Command) error {
+	m, err := loadManifest(cmd)
+	if err != nil {
+		return err
+	}
+
+	sources := newSources()
+	defer closeSources(sources)
+
+	act := installer.NewDryRun(cmd.OutOrStdout(), duration.ToDeployment(), viper.GetInt("parallel"), false, sources)
+	return act.Walk(cmd.Context(), m.Components)
+}
@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/epinio/installer/internal/installer"
+)
+
+// loadManifest loads the manifest and expands its variables. Variables
+// from the vars file are overridden by the environment, which is
+// overridden by --set-var.
+func loadManifest(cmd *cobra.Command) (*installer.Manifest, error) {
+	m, err := installer.Load(viper.GetString("manifest"))
+	if err != nil {
+		return nil, err
+	}
+
+	file := installer.Variables{}
+	if path := viper.GetString("vars-file"); path != "" {
+		file, err = installer.LoadVariables(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pairs, err := cmd.Flags().GetStringArray("set-var")
+	if err != nil {
+		return nil, err
+	}
+	set, err := installer.ParseVariables(pairs)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.Expand(file, installer.VariablesFromEnv(os.Environ()), set); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
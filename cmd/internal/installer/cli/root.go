@@ -2,17 +2,16 @@
 package cli
 
 import (
-	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"runtime"
 
 	"github.com/epinio/epinio/helpers/tracelog"
+	"github.com/epinio/installer/assets"
 	"github.com/epinio/installer/internal/duration"
+	"github.com/epinio/installer/internal/installer"
 	"github.com/epinio/installer/internal/kubernetes/config"
 	"github.com/epinio/installer/internal/version"
-	"github.com/kyokomi/emoji"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -28,8 +27,12 @@ var rootCmd = &cobra.Command{
 // Execute executes the root command.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
+	err := rootCmd.Execute()
+	if err != nil {
+		// stdout might be used for JSON output
+		if err != errReported {
+			fmt.Fprintln(os.Stderr, err)
+		}
 		os.Exit(-1)
 	}
 }
@@ -47,6 +50,36 @@ func init() {
 	tracelog.LoggerFlags(pf, argToEnv)
 	_ = duration.Flags(pf, argToEnv)
 
+	pf.IntP("parallel", "", 0, "Maximum number of components to process at the same time, 0 for no limit")
+	_ = viper.BindPFlag("parallel", pf.Lookup("parallel"))
+	argToEnv["parallel"] = "EPINIO_PARALLEL"
+
+	pf.BoolP("atomic", "", false, "roll back all helm components, if they or their checks fail, like 'rollbackOnFailure'")
+	_ = viper.BindPFlag("atomic", pf.Lookup("atomic"))
+	argToEnv["atomic"] = "EPINIO_ATOMIC"
+
+	pf.StringP("state-namespace", "", "default", "namespace of the config map, which records the install state")
+	_ = viper.BindPFlag("state-namespace", pf.Lookup("state-namespace"))
+	argToEnv["state-namespace"] = "EPINIO_STATE_NAMESPACE"
+
+	pf.StringP("diagnostics-dir", "", "", "write diagnostics of failed checks to this directory")
+	_ = viper.BindPFlag("diagnostics-dir", pf.Lookup("diagnostics-dir"))
+	argToEnv["diagnostics-dir"] = "EPINIO_DIAGNOSTICS_DIR"
+
+	pf.StringP("asset-dir", "", "", "read 'embedded://' paths from this directory, instead of the files compiled into the binary")
+	_ = viper.BindPFlag("asset-dir", pf.Lookup("asset-dir"))
+	argToEnv["asset-dir"] = "EPINIO_ASSET_DIR"
+
+	pf.StringP("git-cache-dir", "", "", "directory of the clones and checkouts of git sources, defaults to the user's cache dir")
+	_ = viper.BindPFlag("git-cache-dir", pf.Lookup("git-cache-dir"))
+	argToEnv["git-cache-dir"] = "EPINIO_GIT_CACHE_DIR"
+
+	pf.StringArrayP("set-var", "", []string{}, "set a manifest variable, key=value, can be repeated")
+
+	pf.StringP("vars-file", "", "", "path of a YAML file with manifest variables")
+	_ = viper.BindPFlag("vars-file", pf.Lookup("vars-file"))
+	argToEnv["vars-file"] = "EPINIO_VARS_FILE"
+
 	pf.BoolP("no-colors", "", false, "Suppress colorized output")
 	_ = viper.BindPFlag("no-colors", pf.Lookup("no-colors"))
 	argToEnv["colors"] = "EPINIO_COLORS"
@@ -55,50 +88,51 @@ func init() {
 
 	rootCmd.AddCommand(CmdInstall)
 	rootCmd.AddCommand(CmdUninstall)
+	rootCmd.AddCommand(CmdUpgrade)
+	rootCmd.AddCommand(CmdValidate)
+	rootCmd.AddCommand(CmdStatus)
+	rootCmd.AddCommand(CmdDiff)
 	rootCmd.AddCommand(cmdVersion)
 }
 
-var cmdVersion = &cobra.Command{
-	Use:   "version",
-	Short: "Print the version number",
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Printf("Epinio Version: %s\n", version.Version)
-		fmt.Printf("Go Version: %s\n", runtime.Version())
-	},
-}
-
-// checkDependencies is a helper which checks the client's environment
-// for the presence of a number of required supporting commands.
-func checkDependencies() error {
-	ok := true
-
-	dependencies := []struct {
-		CommandName string
-	}{
-		{CommandName: "kubectl"},
-		{CommandName: "helm"},
+// outputFormat returns the value of the command's output flag, if it's
+// one of the allowed formats
+func outputFormat(cmd *cobra.Command, allowed ...string) (string, error) {
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return "", err
 	}
-
-	for _, dependency := range dependencies {
-		_, err := exec.LookPath(dependency.CommandName)
-		if err != nil {
-			fmt.Println(emoji.Sprintf(":fire:Not found: %s", dependency.CommandName))
-			ok = false
+	for _, a := range allowed {
+		if output == a {
+			return output, nil
 		}
 	}
+	return "", fmt.Errorf("unknown output format '%s', use one of %v", output, allowed)
+}
 
-	if ok {
-		return nil
+// newSources returns the embedded files, which are read from the asset dir
+// if it's set, and the git cache
+func newSources() *installer.Sources {
+	dir := viper.GetString("git-cache-dir")
+	if dir == "" {
+		dir = installer.DefaultGitCacheDir()
 	}
-
-	return errors.New("please check your PATH, some of our dependencies were not found")
+	return installer.NewSources(installer.NewAssets(assets.Embedded(), viper.GetString("asset-dir")), installer.NewGitCache(dir))
 }
 
-// exitfIfError stops the application with an error exit code, after
-// printing error and message, if there is an error.
-func exitfIfError(err error, message string) {
-	if err != nil {
-		fmt.Fprintln(os.Stderr, fmt.Errorf("%s: %w", message, err))
-		os.Exit(1)
+// closeSources removes the extracted embedded files, failing to do so is
+// only reported
+func closeSources(sources *installer.Sources) {
+	if err := sources.Cleanup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
 	}
 }
+
+var cmdVersion = &cobra.Command{
+	Use:   "version",
+	Short: "Print the version number",
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Printf("Epinio Version: %s\n", version.Version)
+		fmt.Printf("Go Version: %s\n", runtime.Version())
+	},
+}
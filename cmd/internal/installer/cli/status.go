@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/epinio/epinio/helpers/tracelog"
+	"github.com/epinio/installer/internal/duration"
+	"github.com/epinio/installer/internal/installer"
+	"github.com/epinio/installer/internal/kubernetes"
+)
+
+// errReported makes the installer exit non-zero, without printing an
+// error, as the command printed its result already
+var errReported = errors.New("reported")
+
+var CmdStatus = &cobra.Command{
+	Use:   "status",
+	Short: "show whether the manifest's components are installed and healthy",
+	Long:  `show whether the manifest's components are installed and their checks pass, exits non-zero if any component is unhealthy`,
+	Args:  cobra.ExactArgs(0),
+	RunE:  status,
+}
+
+func init() {
+	CmdStatus.Flags().StringP("output", "o", "table", "output format, 'table' or 'json'")
+}
+
+func status(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	output, err := outputFormat(cmd, "table", "json")
+	if err != nil {
+		return err
+	}
+
+	ctx := cmd.Context()
+
+	sources := newSources()
+	defer closeSources(sources)
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return err
+	}
+
+	redactor := installer.NewRedactor()
+	log := redactor.Logger(tracelog.NewLogger()).WithName("EpinioInstaller")
+	resolver := installer.NewResolver(cluster, redactor)
+
+	m, err := loadManifest(cmd)
+	if err != nil {
+		return err
+	}
+
+	ca := installer.NewComponentActions(cluster, log, duration.ToDeployment(), nil, nil)
+	report := installer.NewStatus(cluster, log, ca, sources, resolver).Report(ctx, m.Components)
+
+	out := cmd.OutOrStdout()
+	if output == "json" {
+		err = report.WriteJSON(out)
+	} else {
+		err = report.WriteTable(out)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !report.Healthy() {
+		if output == "table" {
+			fmt.Fprintf(out, "\n%d component(s) unhealthy: %v\n", len(report.Unhealthy()), report.Unhealthy())
+		}
+		return errReported
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
@@ -18,21 +19,43 @@ var CmdUninstall = &cobra.Command{
 	RunE:  uninstall,
 }
 
+func init() {
+	CmdUninstall.Flags().Bool("dry-run", false, "print what would be done, without changing the cluster")
+	CmdUninstall.Flags().StringP("output", "o", "text", "output format, 'text' for logs only or 'json' for a stream of progress events")
+}
+
 func uninstall(cmd *cobra.Command, args []string) error {
 	cmd.SilenceUsage = true
-	exitfIfError(checkDependencies(), "Cannot operate")
+
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return err
+	}
+	output, err := outputFormat(cmd, "text", "json")
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		if output == "json" {
+			return errors.New("--output json is not supported with --dry-run")
+		}
+		return uninstallDryRun(cmd)
+	}
 
 	ctx := cmd.Context()
 
+	sources := newSources()
+	defer closeSources(sources)
+
 	cluster, err := kubernetes.GetCluster(ctx)
 	if err != nil {
 		return err
 	}
 
+	// values are not resolved for uninstall, so there are no secrets to redact
 	log := tracelog.NewLogger().WithName("EpinioUninstaller")
 
-	path := viper.GetString("manifest")
-	m, err := installer.Load(path)
+	m, err := loadManifest(cmd)
 	if err != nil {
 		return err
 	}
@@ -44,10 +67,30 @@ func uninstall(cmd *cobra.Command, args []string) error {
 
 	log.Info("plan", "components", p.String())
 
-	ca := installer.NewComponentActions(cluster, log, duration.ToDeployment())
-	act := installer.NewUninstall(cluster, log, ca)
+	// progress events are written to stdout, logs go to stderr
+	var events *installer.Events
+	if output == "json" {
+		events = installer.NewEvents(cmd.OutOrStdout(), nil)
+	}
+
+	diagnostics := installer.NewDiagnostics(cluster.Kubectl, viper.GetString("diagnostics-dir"), nil)
+	ca := installer.NewComponentActions(cluster, log, duration.ToDeployment(), diagnostics, events)
+	store := installer.NewStateStore(cluster.Kubectl, viper.GetString("state-namespace"))
+	act := installer.NewStateful(installer.NewUninstall(cluster, log, ca, sources), store, log, true)
+
+	w := &installer.Walker{Parallel: viper.GetInt("parallel"), Events: events}
+	return w.ReverseWalk(ctx, m.Components, act)
+}
+
+func uninstallDryRun(cmd *cobra.Command) error {
+	m, err := loadManifest(cmd)
+	if err != nil {
+		return err
+	}
 
-	installer.ReverseWalk(ctx, m.Components, act)
+	sources := newSources()
+	defer closeSources(sources)
 
-	return nil
+	act := installer.NewDryRun(cmd.OutOrStdout(), duration.ToDeployment(), viper.GetInt("parallel"), true, sources)
+	return act.Walk(cmd.Context(), m.Components)
 }
@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/epinio/epinio/helpers/tracelog"
+	"github.com/epinio/installer/internal/duration"
+	"github.com/epinio/installer/internal/installer"
+	"github.com/epinio/installer/internal/kubernetes"
+)
+
+var CmdUpgrade = &cobra.Command{
+	Use:   "upgrade",
+	Short: "upgrade the components, which changed since they were installed",
+	Long:  `compare the manifest with the recorded install state and only install or upgrade the components, which changed`,
+	Args:  cobra.ExactArgs(0),
+	RunE:  upgrade,
+}
+
+func init() {
+	CmdUpgrade.Flags().Bool("dry-run", false, "print which components would be installed or upgraded, without changing the cluster")
+	CmdUpgrade.Flags().StringP("output", "o", "text", "output format, 'text' for a summary or 'json' for a stream of progress events")
+}
+
+func upgrade(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return err
+	}
+	output, err := outputFormat(cmd, "text", "json")
+	if err != nil {
+		return err
+	}
+
+	ctx := cmd.Context()
+
+	sources := newSources()
+	defer closeSources(sources)
+
+	cluster, err := kubernetes.GetCluster(ctx)
+	if err != nil {
+		return err
+	}
+
+	// secrets resolved from value sources are redacted from all log lines
+	redactor := installer.NewRedactor()
+	log := redactor.Logger(tracelog.NewLogger()).WithName("EpinioUpgrader")
+	resolver := installer.NewResolver(cluster, redactor)
+
+	m, err := loadManifest(cmd)
+	if err != nil {
+		return err
+	}
+
+	p, err := installer.BuildPlan(m.Components)
+	if err != nil {
+		return err
+	}
+
+	// the definitions recorded in the state include the contents of sources and values files
+	if err := m.Components.ReadContents(ctx, sources); err != nil {
+		return err
+	}
+
+	store := installer.NewStateStore(cluster.Kubectl, viper.GetString("state-namespace"))
+	state, err := store.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	up := installer.PlanUpgrade(m.Components, state)
+	log.Info("plan", "components", p.String(), "changed", fmt.Sprint(up.Changed()))
+
+	out := cmd.OutOrStdout()
+	if dryRun {
+		up.WritePlan(out)
+		return nil
+	}
+
+	// progress events are written to stdout, logs go to stderr
+	var events *installer.Events
+	if output == "json" {
+		events = installer.NewEvents(out, redactor)
+	}
+
+	diagnostics := installer.NewDiagnostics(cluster.Kubectl, viper.GetString("diagnostics-dir"), redactor)
+	ca := installer.NewComponentActions(cluster, log, duration.ToDeployment(), diagnostics, events)
+	install := installer.NewInstall(cluster, log, ca, sources, resolver, store, viper.GetBool("atomic"))
+	act := installer.NewStateful(installer.NewUpgrade(install, state), store, log, false)
+
+	// unchanged components are skipped
+	if err := act.PrepareUpgrade(ctx, m.Components, up); err != nil {
+		return err
+	}
+
+	w := &installer.Walker{Parallel: viper.GetInt("parallel"), Events: events}
+	err = w.Walk(ctx, m.Components, act)
+
+	if output == "text" {
+		up.WriteSummary(out, err)
+	}
+	return err
+}
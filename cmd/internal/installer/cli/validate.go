@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/epinio/installer/internal/installer"
+)
+
+var CmdValidate = &cobra.Command{
+	Use:   "validate",
+	Short: "validate the manifest without connecting to a cluster",
+	Long:  `validate the manifest and list all problems found, does not need a kubernetes cluster`,
+	Args:  cobra.ExactArgs(0),
+	RunE:  validate,
+}
+
+func validate(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	path := viper.GetString("manifest")
+	problems, err := installer.Validate(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to load manifest '%s'", path)
+	}
+
+	if len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		return fmt.Errorf("manifest '%s' has %d problem(s)", path, len(problems))
+	}
+
+	// all variables used by templates need to be set
+	if _, err := loadManifest(cmd); err != nil {
+		return err
+	}
+
+	fmt.Printf("manifest '%s' is valid\n", path)
+	return nil
+}
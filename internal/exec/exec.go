@@ -6,10 +6,8 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 
 	"github.com/codeskyblue/kexec"
-	"github.com/pkg/errors"
 )
 
 type ExternalFuncWithString func() (output string, err error)
@@ -82,19 +80,3 @@ func CreateTmpFile(contents string) (string, error) {
 
 	return tmpfile.Name(), nil
 }
-
-// Kubectl invokes the `kubectl` command in PATH, running the specified command.
-// It returns the command output and/or error.
-func Kubectl(command ...string) (string, error) {
-	_, err := exec.LookPath("kubectl")
-	if err != nil {
-		return "", errors.Wrap(err, "kubectl not in path")
-	}
-
-	currentdir, err := os.Getwd()
-	if err != nil {
-		return "", err
-	}
-
-	return RunProc(currentdir, false, "kubectl", command...)
-}
@@ -0,0 +1,123 @@
+package installer
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// EmbeddedScheme prefixes paths of files, which are compiled into the
+// binary, e.g. 'embedded://cert-manager-v1.5.4.tgz'
+const EmbeddedScheme = "embedded://"
+
+// IsEmbedded is true if the path refers to an embedded file
+func IsEmbedded(path string) bool {
+	return strings.HasPrefix(path, EmbeddedScheme)
+}
+
+// Assets resolves embedded paths to files on disk. Embedded files are
+// extracted into a temporary directory when they are first used, unless
+// they are read from an asset directory instead.
+type Assets struct {
+	files fs.FS
+	dir   string
+
+	mu        sync.Mutex
+	tmp       string
+	extracted map[string]string
+}
+
+// NewAssets returns assets for the files, if dir is not empty, it
+// replaces the files
+func NewAssets(files fs.FS, dir string) *Assets {
+	return &Assets{
+		files:     files,
+		dir:       dir,
+		extracted: map[string]string{},
+	}
+}
+
+// Path returns the path of the file on disk, paths which are not
+// embedded are returned unchanged. Embedded directories, like unpacked
+// charts, are extracted as a whole.
+func (a *Assets) Path(p string) (string, error) {
+	if !IsEmbedded(p) {
+		return p, nil
+	}
+
+	name := path.Clean(strings.TrimPrefix(p, EmbeddedScheme))
+	if !fs.ValidPath(name) || name == "." {
+		return "", errors.Errorf("invalid embedded path '%s'", p)
+	}
+
+	if a.dir != "" {
+		return filepath.Join(a.dir, filepath.FromSlash(name)), nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if local, ok := a.extracted[name]; ok {
+		return local, nil
+	}
+
+	if _, err := fs.Stat(a.files, name); err != nil {
+		return "", errors.Wrapf(err, "embedded file '%s' not found", name)
+	}
+
+	if a.tmp == "" {
+		tmp, err := os.MkdirTemp("", "epinio-installer-assets-")
+		if err != nil {
+			return "", err
+		}
+		a.tmp = tmp
+	}
+
+	local := filepath.Join(a.tmp, filepath.FromSlash(name))
+	if err := a.extract(name, local); err != nil {
+		return "", errors.Wrapf(err, "failed to extract embedded file '%s'", name)
+	}
+	a.extracted[name] = local
+	return local, nil
+}
+
+// extract copies the file or directory name to local
+func (a *Assets) extract(name string, local string) error {
+	return fs.WalkDir(a.files, name, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(local, filepath.FromSlash(strings.TrimPrefix(p, name)))
+		if d.IsDir() {
+			return os.MkdirAll(target, 0700)
+		}
+
+		b, err := fs.ReadFile(a.files, p)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+			return err
+		}
+		return os.WriteFile(target, b, 0600)
+	})
+}
+
+// Cleanup removes the extracted files
+func (a *Assets) Cleanup() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.extracted = map[string]string{}
+	if a.tmp == "" {
+		return nil
+	}
+	err := os.RemoveAll(a.tmp)
+	a.tmp = ""
+	return err
+}
@@ -0,0 +1,108 @@
+package installer_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/go-logr/logr"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chartutil"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+
+	"github.com/epinio/installer/internal/installer"
+)
+
+var _ = Describe("Assets", func() {
+	var assets *installer.Assets
+
+	BeforeEach(func() {
+		assets = installer.NewAssets(os.DirFS(assetPath("")), "")
+	})
+
+	AfterEach(func() {
+		Expect(assets.Cleanup()).To(Succeed())
+	})
+
+	It("returns paths which are not embedded unchanged", func() {
+		p, err := assets.Path("assets/charts/hello")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p).To(Equal("assets/charts/hello"))
+	})
+
+	It("extracts embedded files", func() {
+		p, err := assets.Path("embedded://cluster-issuer.yaml")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p).ToNot(HavePrefix("embedded://"))
+
+		b, err := ioutil.ReadFile(p)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(b)).To(ContainSubstring("kind: ClusterIssuer"))
+
+		again, err := assets.Path("embedded://cluster-issuer.yaml")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(again).To(Equal(p))
+	})
+
+	It("extracts embedded directories and removes them on cleanup", func() {
+		p, err := assets.Path("embedded://charts/hello/")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(filepath.Join(p, "Chart.yaml")).To(BeARegularFile())
+		Expect(filepath.Join(p, "templates", "configmap.yaml")).To(BeARegularFile())
+
+		Expect(assets.Cleanup()).To(Succeed())
+		Expect(p).ToNot(BeADirectory())
+	})
+
+	It("fails for missing and invalid embedded paths", func() {
+		_, err := assets.Path("embedded://missing.tgz")
+		Expect(err).To(MatchError(ContainSubstring("embedded file 'missing.tgz' not found")))
+
+		_, err = assets.Path("embedded://../secret.yaml")
+		Expect(err).To(MatchError("invalid embedded path 'embedded://../secret.yaml'"))
+	})
+
+	It("reads embedded paths from the asset dir", func() {
+		assets = installer.NewAssets(os.DirFS(assetPath("")), "/opt/assets")
+		p, err := assets.Path("embedded://charts/hello")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p).To(Equal(filepath.Join("/opt/assets", "charts", "hello")))
+	})
+
+	Context("as sources of components", func() {
+		It("installs embedded charts with embedded values files", func() {
+			cfg := &action.Configuration{
+				Releases:     storage.Init(driver.NewMemory()),
+				KubeClient:   &kubefake.PrintingKubeClient{Out: ioutil.Discard},
+				Capabilities: chartutil.DefaultCapabilities,
+			}
+			c := installer.Component{
+				ID:          "hello",
+				Type:        installer.Helm,
+				Namespace:   "hello",
+				Source:      installer.Source{Name: "hello", Path: "embedded://charts/hello"},
+				ValuesFiles: []string{"embedded://values/hello-override.yaml"},
+			}
+
+			sources := installer.NewSources(assets, nil)
+			Expect(installer.NewHelmClientForConfig(cfg, sources).Update(context.TODO(), logr.Discard(), c)).To(Succeed())
+
+			rel, err := cfg.Releases.Last("hello")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rel.Chart.Metadata.Name).To(Equal("hello"))
+			Expect(rel.Config).To(HaveKeyWithValue("replicas", BeNumerically("==", 2)))
+		})
+
+		It("fails for embedded paths without assets", func() {
+			c := installer.Component{ID: "hello", Type: installer.YAML, Source: installer.Source{Path: "embedded://cluster-issuer.yaml"}}
+			err := installer.Components{c}.ReadContents(context.TODO(), installer.NewSources(nil, nil))
+			Expect(err).To(MatchError(ContainSubstring("no embedded files to read 'embedded://cluster-issuer.yaml' from")))
+		})
+	})
+})
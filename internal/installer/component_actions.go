@@ -2,47 +2,306 @@ package installer
 
 import (
 	"context"
+	"fmt"
 	"time"
 
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	typedbatchv1 "k8s.io/client-go/kubernetes/typed/batch/v1"
+
 	"github.com/epinio/installer/internal/duration"
 	"github.com/epinio/installer/internal/kubernetes"
-	"github.com/go-logr/logr"
 )
 
 type ComponentActions struct {
-	cluster *kubernetes.Cluster
-	log     logr.Logger
-	timeout time.Duration
+	cluster     *kubernetes.Cluster
+	conditions  *ConditionClient
+	diagnostics *Diagnostics
+	events      *Events
+	jobs        *typedbatchv1.BatchV1Client
+	log         logr.Logger
+	timeout     time.Duration
 }
 
-// NewComponentActions returns the runner for component actions, like checks and waitFors
-func NewComponentActions(cluster *kubernetes.Cluster, log logr.Logger, timeout time.Duration) *ComponentActions {
+// NewComponentActions returns the runner for component actions, like
+// checks and waitFors. Failed checks are explained by the diagnostics.
+// Checks are reported to the event stream, if it's not nil.
+func NewComponentActions(cluster *kubernetes.Cluster, log logr.Logger, timeout time.Duration, diagnostics *Diagnostics, events *Events) *ComponentActions {
 	return &ComponentActions{
-		cluster: cluster,
-		log:     log,
-		timeout: timeout,
+		cluster:     cluster,
+		conditions:  NewConditionClient(cluster.Dynamic, cluster.Mapper),
+		diagnostics: diagnostics,
+		events:      events,
+		jobs:        typedbatchv1.New(cluster.Kubectl.BatchV1().RESTClient()),
+		log:         log,
+		timeout:     timeout,
 	}
 }
 
+// Run waits for the check to pass, polling with the check's wait policy
 func (ca ComponentActions) Run(ctx context.Context, c Component, chk ComponentAction) error {
-	namespace := c.Namespace
-	if chk.Namespace != "" {
-		namespace = chk.Namespace
+	start := time.Now()
+	ca.events.Emit(Event{Type: EventCheckStarted, Component: c.ID, Check: checkSubject(chk), CheckType: chk.Type})
+
+	err := ca.run(ctx, c, chk)
+
+	ev := Event{Type: EventCheckPassed, Component: c.ID, Check: checkSubject(chk), CheckType: chk.Type, Duration: Duration(time.Since(start))}
+	if err != nil {
+		ev.Type = EventCheckFailed
+		ev.Error = err.Error()
+	}
+	ca.events.Emit(ev)
+	return err
+}
+
+func (ca ComponentActions) run(ctx context.Context, c Component, chk ComponentAction) error {
+	namespace := checkNamespace(c, chk)
+	policy := ca.policy(chk)
+	ca.log.Info("check", "component", c.ID, "checkType", string(chk.Type),
+		"timeout", policy.Timeout.String(), "interval", policy.Interval.String(), "retries", policy.Retries)
+
+	if chk.Type == Condition {
+		return ca.diagnostics.Diagnose(ctx, c, chk, ca.conditions.Wait(ctx, namespace, chk, policy))
+	}
+
+	cond := ca.condition(namespace, chk)
+	if cond == nil {
+		ca.log.Info("skip unknown check type", "component", c.ID, "checkType", string(chk.Type))
+		return nil
+	}
+	return ca.diagnostics.Diagnose(ctx, c, chk, policy.Poll(ctx, cond))
+}
+
+// Check evaluates the check once, without waiting. If it doesn't pass,
+// the message describes why.
+func (ca ComponentActions) Check(ctx context.Context, c Component, chk ComponentAction) (bool, string, error) {
+	namespace := checkNamespace(c, chk)
+	if chk.Type == Condition {
+		return ca.conditions.Check(ctx, namespace, chk)
+	}
+
+	cond := ca.condition(namespace, chk)
+	if cond == nil {
+		return true, fmt.Sprintf("skipped unknown check type '%s'", chk.Type), nil
+	}
+
+	ok, err := cond(ctx)
+	if err != nil {
+		return false, err.Error(), err
+	}
+	if !ok {
+		return false, pendingMessage(chk), nil
+	}
+	return true, "", nil
+}
+
+// pendingMessage describes why a check of this type doesn't pass yet
+func pendingMessage(chk ComponentAction) string {
+	switch chk.Type {
+	case Pod:
+		return "pods are not ready"
+	case Loadbalancer:
+		return "service has no loadbalancer"
+	case CRD:
+		return "CRD is not established"
+	case Job:
+		return "job is not complete"
+	case Deployment, StatefulSet, DaemonSet:
+		return "rollout is not complete"
 	}
+	return "not found"
+}
+
+// condition returns the function polled by the check, nil for unknown
+// check types. Its API calls use the context passed to it.
+func (ca ComponentActions) condition(namespace string, chk ComponentAction) func(context.Context) (bool, error) {
 	switch chk.Type {
 	case Pod:
-		if err := ca.cluster.WaitForPodBySelector(ctx, namespace, chk.Selector, duration.ToPodReady()); err != nil {
-			return err
+		return func(ctx context.Context) (bool, error) {
+			return ca.cluster.ArePodsRunning(ctx, chk.Selector, namespace)()
 		}
 	case Loadbalancer:
-		return ca.cluster.WaitUntilServiceHasLoadBalancer(ctx, namespace, chk.Selector, duration.ToServiceLoadBalancer())
+		return func(ctx context.Context) (bool, error) {
+			return ca.cluster.ServiceHasLoadBalancer(ctx, namespace, chk.Selector)()
+		}
 	case CRD:
-		return ca.cluster.WaitForCRD(ctx, chk.Selector, ca.timeout)
+		return func(ctx context.Context) (bool, error) {
+			cond, err := ca.cluster.IsCRDEstablished(ctx, chk.Selector)
+			if err != nil {
+				return false, err
+			}
+			return cond()
+		}
 	case Job:
-		if err := ca.cluster.WaitForJobCompleted(ctx, namespace, chk.Selector, ca.timeout); err != nil {
-			return err
+		return func(ctx context.Context) (bool, error) {
+			return ca.cluster.IsJobCompleted(ctx, ca.jobs, chk.Selector, namespace)()
+		}
+	case Deployment:
+		return func(ctx context.Context) (bool, error) {
+			return ca.cluster.IsDeploymentCompleted(ctx, chk.Selector, namespace)()
+		}
+	case StatefulSet:
+		return func(ctx context.Context) (bool, error) {
+			return ca.cluster.IsStatefulSetCompleted(ctx, chk.Selector, namespace)()
+		}
+	case DaemonSet:
+		return func(ctx context.Context) (bool, error) {
+			return ca.cluster.IsDaemonSetCompleted(ctx, chk.Selector, namespace)()
+		}
+	case Secret:
+		return func(ctx context.Context) (bool, error) {
+			return ca.cluster.SecretExists(ctx, namespace, chk.Selector)()
+		}
+	case NamespaceCheck:
+		return func(ctx context.Context) (bool, error) {
+			return ca.cluster.NamespaceExists(ctx, chk.Selector)
 		}
 	}
-
 	return nil
 }
+
+// WaitPolicy is the effective timeout, poll interval and number of
+// tolerated errors of a check
+type WaitPolicy struct {
+	Timeout  time.Duration
+	Interval time.Duration
+	Retries  int
+}
+
+// policy returns the check's wait policy, the defaults are the check
+// type's timeout, polling every second and no retries
+func (ca ComponentActions) policy(chk ComponentAction) WaitPolicy {
+	p := WaitPolicy{
+		Timeout:  checkTimeout(chk, ca.timeout),
+		Interval: time.Second,
+		Retries:  chk.Retries,
+	}
+	if chk.Interval > 0 {
+		p.Interval = time.Duration(chk.Interval)
+	}
+	return p
+}
+
+// Poll calls cond every interval until it returns true. Cond's context
+// has the timeout as deadline, so it also bounds hanging API calls. It
+// fails when the timeout is reached, when cond returned more errors than
+// retries, or with ctx's error when ctx is done first.
+func (p WaitPolicy) Poll(ctx context.Context, cond func(context.Context) (bool, error)) error {
+	pollCtx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	failures := 0
+	var last error
+	err := wait.PollImmediateUntil(p.Interval, func() (bool, error) {
+		ok, err := cond(pollCtx)
+		if err != nil {
+			failures++
+			if failures > p.Retries {
+				return false, err
+			}
+			last = err
+			return false, nil
+		}
+		return ok, nil
+	}, pollCtx.Done())
+	if err == nil {
+		return nil
+	}
+
+	// e.g. cancelled, because another component failed
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if err != wait.ErrWaitTimeout && pollCtx.Err() != nil {
+		// the timeout interrupted a call of cond
+		last, err = err, wait.ErrWaitTimeout
+	}
+	if err == wait.ErrWaitTimeout {
+		err = errors.Wrapf(err, "gave up after %s", p.Timeout)
+		if last != nil {
+			err = errors.Wrapf(err, "last error: %v", last)
+		}
+	}
+	return err
+}
+
+// componentContext returns a context with the component's timeout as
+// deadline, if it has one
+func componentContext(ctx context.Context, c Component) (context.Context, context.CancelFunc) {
+	if c.Timeout > 0 {
+		return context.WithTimeout(ctx, time.Duration(c.Timeout))
+	}
+	return context.WithCancel(ctx)
+}
+
+// componentError explains errors caused by the component's timeout, ctx
+// is the component's context
+func componentError(ctx context.Context, c Component, err error) error {
+	if err != nil && c.Timeout > 0 && ctx.Err() == context.DeadlineExceeded {
+		return errors.Wrapf(err, "component timed out after %s", c.Timeout)
+	}
+	return err
+}
+
+// checkNamespace returns the namespace of the check, which defaults to the component's
+func checkNamespace(c Component, chk ComponentAction) string {
+	if chk.Namespace != "" {
+		return chk.Namespace
+	}
+	return c.Namespace
+}
+
+// checkTimeout returns the check's timeout, which defaults to the one
+// used for the check type
+func checkTimeout(chk ComponentAction, timeout time.Duration) time.Duration {
+	if chk.Timeout > 0 {
+		return time.Duration(chk.Timeout)
+	}
+
+	switch chk.Type {
+	case Pod:
+		return duration.ToPodReady()
+	case Loadbalancer:
+		return duration.ToServiceLoadBalancer()
+	case Deployment, StatefulSet, DaemonSet:
+		return duration.ToDeployment()
+	}
+	return timeout
+}
+
+// describeCheck returns a human readable description of what the check waits for
+func describeCheck(c Component, chk ComponentAction, timeout time.Duration) string {
+	timeout = checkTimeout(chk, timeout)
+	namespace := checkNamespace(c, chk)
+	switch chk.Type {
+	case Pod:
+		return fmt.Sprintf("wait up to %s for pods with selector '%s' in namespace '%s' to be ready", timeout, chk.Selector, namespace)
+	case Loadbalancer:
+		return fmt.Sprintf("wait up to %s for service '%s' in namespace '%s' to have a loadbalancer", timeout, chk.Selector, namespace)
+	case CRD:
+		return fmt.Sprintf("wait up to %s for CRD '%s' to be established", timeout, chk.Selector)
+	case Job:
+		return fmt.Sprintf("wait up to %s for job '%s' in namespace '%s' to complete", timeout, chk.Selector, namespace)
+	case Deployment, StatefulSet, DaemonSet:
+		return fmt.Sprintf("wait up to %s for the rollout of %s '%s' in namespace '%s'", timeout, chk.Type, chk.Selector, namespace)
+	case Secret:
+		return fmt.Sprintf("wait up to %s for secret '%s' in namespace '%s' to exist", timeout, chk.Selector, namespace)
+	case NamespaceCheck:
+		return fmt.Sprintf("wait up to %s for namespace '%s' to exist", timeout, chk.Selector)
+	case Condition:
+		objects := fmt.Sprintf("%s '%s'", chk.Kind, chk.Name)
+		if chk.Name == "" {
+			objects = fmt.Sprintf("%s objects with selector '%s'", chk.Kind, chk.Selector)
+		}
+		if namespace != "" {
+			objects += fmt.Sprintf(" in namespace '%s'", namespace)
+		}
+		if chk.JSONPath != "" {
+			return fmt.Sprintf("wait up to %s for %s to have %s '%s'", timeout, objects, chk.JSONPath, chk.Value)
+		}
+		return fmt.Sprintf("wait up to %s for %s to have condition %s=%s", timeout, objects, chk.Condition, conditionStatus(chk))
+	}
+	return fmt.Sprintf("skip unknown check type '%s'", chk.Type)
+}
@@ -0,0 +1,104 @@
+package installer_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/epinio/installer/internal/installer"
+)
+
+var _ = Describe("WaitPolicy", func() {
+	It("polls until the condition is true", func() {
+		calls := 0
+		err := policy(time.Second).Poll(context.TODO(), func(context.Context) (bool, error) {
+			calls++
+			return calls == 3, nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(calls).To(Equal(3))
+	})
+
+	It("gives up after the timeout", func() {
+		start := time.Now()
+		err := policy(100*time.Millisecond).Poll(context.TODO(), func(context.Context) (bool, error) {
+			return false, nil
+		})
+		Expect(err).To(MatchError("gave up after 100ms: timed out waiting for the condition"))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	})
+
+	It("tolerates errors up to the number of retries", func() {
+		calls := 0
+		p := policy(time.Second)
+		p.Retries = 2
+		err := p.Poll(context.TODO(), func(context.Context) (bool, error) {
+			calls++
+			if calls <= 2 {
+				return false, errors.New("unavailable")
+			}
+			return true, nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		calls = 0
+		err = p.Poll(context.TODO(), func(context.Context) (bool, error) {
+			calls++
+			return false, errors.New("unavailable")
+		})
+		Expect(err).To(MatchError("unavailable"))
+		Expect(calls).To(Equal(3))
+	})
+
+	It("reports the last tolerated error on timeout", func() {
+		p := policy(100 * time.Millisecond)
+		p.Retries = 1000
+		err := p.Poll(context.TODO(), func(context.Context) (bool, error) {
+			return false, errors.New("unavailable")
+		})
+		Expect(err).To(MatchError(ContainSubstring("last error: unavailable")))
+	})
+
+	It("bounds hanging calls by the timeout", func() {
+		start := time.Now()
+		err := policy(100*time.Millisecond).Poll(context.TODO(), func(ctx context.Context) (bool, error) {
+			<-ctx.Done()
+			return false, ctx.Err()
+		})
+		Expect(err).To(MatchError(ContainSubstring("gave up after 100ms")))
+		Expect(err).To(MatchError(ContainSubstring("last error: context deadline exceeded")))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	})
+
+	It("stops when the context is done", func() {
+		ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+		defer cancel()
+		err := policy(time.Minute).Poll(ctx, func(context.Context) (bool, error) {
+			return false, nil
+		})
+		Expect(err).To(Equal(context.DeadlineExceeded))
+
+		ctx, cancel = context.WithCancel(context.TODO())
+		cancel()
+		err = policy(time.Minute).Poll(ctx, func(context.Context) (bool, error) {
+			return false, nil
+		})
+		Expect(err).To(Equal(context.Canceled))
+	})
+})
+
+var _ = Describe("Check timeouts", func() {
+	It("loads timeouts, intervals and retries from the manifest", func() {
+		m, err := installer.Load(assetPath("dryrun-manifest.yml"))
+		Expect(err).ToNot(HaveOccurred())
+
+		c := m.Components[1]
+		Expect(c.Timeout).To(Equal(installer.Duration(20 * time.Minute)))
+		Expect(c.WaitComplete[0].Timeout).To(Equal(installer.Duration(90 * time.Second)))
+		Expect(c.WaitComplete[0].Interval).To(Equal(installer.Duration(5 * time.Second)))
+		Expect(c.WaitComplete[0].Retries).To(Equal(3))
+	})
+})
@@ -0,0 +1,186 @@
+package installer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// ConditionClient waits for arbitrary resources to reach a status, it
+// implements the 'condition' check
+type ConditionClient struct {
+	dynamic dynamic.Interface
+	mapper  meta.RESTMapper
+}
+
+// NewConditionClient returns a client, which looks up resources with the mapper
+func NewConditionClient(dyn dynamic.Interface, mapper meta.RESTMapper) *ConditionClient {
+	return &ConditionClient{
+		dynamic: dyn,
+		mapper:  mapper,
+	}
+}
+
+// Wait polls until the object named in the check, or all objects matching
+// its selector, have the expected condition status or JSONPath value.
+// Missing objects and kinds are waited for, too.
+func (cc *ConditionClient) Wait(ctx context.Context, namespace string, chk ComponentAction, policy WaitPolicy) error {
+	last := ""
+	err := policy.Poll(ctx, func(ctx context.Context) (bool, error) {
+		ok, msg, err := cc.Check(ctx, namespace, chk)
+		if err == nil {
+			// errors are reported by the policy
+			last = msg
+		}
+		return ok, err
+	})
+
+	if errors.Cause(err) == wait.ErrWaitTimeout && last != "" {
+		return errors.Wrap(err, last)
+	}
+	return err
+}
+
+// Check evaluates the check once. If it doesn't pass, the message
+// describes the actual state.
+func (cc *ConditionClient) Check(ctx context.Context, namespace string, chk ComponentAction) (bool, string, error) {
+	objs, err := cc.get(ctx, namespace, chk)
+	if err != nil {
+		return false, err.Error(), err
+	}
+	if len(objs) == 0 {
+		return false, "no matching objects", nil
+	}
+
+	for _, obj := range objs {
+		ok, msg, err := matchCondition(obj, chk)
+		if err != nil || !ok {
+			return false, fmt.Sprintf("%s '%s': %s", obj.GetKind(), obj.GetName(), msg), err
+		}
+	}
+	return true, "", nil
+}
+
+// get returns the checked objects, an empty list if they or their kind
+// don't exist yet
+func (cc *ConditionClient) get(ctx context.Context, namespace string, chk ComponentAction) ([]*unstructured.Unstructured, error) {
+	gvk := schema.FromAPIVersionAndKind(chk.APIVersion, chk.Kind)
+	mapping, err := cc.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		// the CRD might not be installed yet
+		if m, ok := cc.mapper.(meta.ResettableRESTMapper); ok {
+			m.Reset()
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ri dynamic.ResourceInterface = cc.dynamic.Resource(mapping.Resource)
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		ri = cc.dynamic.Resource(mapping.Resource).Namespace(namespace)
+	}
+
+	if chk.Name != "" {
+		obj, err := ri.Get(ctx, chk.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []*unstructured.Unstructured{obj}, nil
+	}
+
+	list, err := ri.List(ctx, metav1.ListOptions{LabelSelector: chk.Selector})
+	if err != nil {
+		return nil, err
+	}
+	objs := make([]*unstructured.Unstructured, 0, len(list.Items))
+	for i := range list.Items {
+		objs = append(objs, &list.Items[i])
+	}
+	return objs, nil
+}
+
+// matchCondition returns true if the object matches the check's
+// expectation, otherwise a message describing the actual state
+func matchCondition(obj *unstructured.Unstructured, chk ComponentAction) (bool, string, error) {
+	if chk.JSONPath != "" {
+		actual, err := evalJSONPath(obj, chk.JSONPath)
+		if err != nil {
+			return false, err.Error(), err
+		}
+		if actual != chk.Value {
+			return false, fmt.Sprintf("%s is '%s', waiting for '%s'", chk.JSONPath, actual, chk.Value), nil
+		}
+		return true, "", nil
+	}
+
+	expected := conditionStatus(chk)
+	conditions, _, err := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	if err != nil {
+		return false, err.Error(), err
+	}
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if !ok || cond["type"] != chk.Condition {
+			continue
+		}
+		status := fmt.Sprint(cond["status"])
+		if status != expected {
+			return false, fmt.Sprintf("condition %s is %s, waiting for %s", chk.Condition, status, expected), nil
+		}
+		return true, "", nil
+	}
+	return false, fmt.Sprintf("condition %s not found", chk.Condition), nil
+}
+
+// evalJSONPath returns the result of the JSONPath for the object
+func evalJSONPath(obj *unstructured.Unstructured, path string) (string, error) {
+	jp, err := parseJSONPath(path)
+	if err != nil {
+		return "", err
+	}
+
+	var b bytes.Buffer
+	if err := jp.Execute(&b, obj.Object); err != nil {
+		return "", errors.Wrapf(err, "failed to evaluate JSONPath '%s'", path)
+	}
+	return b.String(), nil
+}
+
+// parseJSONPath parses a kubectl style JSONPath template, braces are
+// optional, e.g. '.status.phase'
+func parseJSONPath(path string) (*jsonpath.JSONPath, error) {
+	tmpl := path
+	if !strings.HasPrefix(tmpl, "{") {
+		tmpl = "{" + tmpl + "}"
+	}
+
+	jp := jsonpath.New("check").AllowMissingKeys(true)
+	if err := jp.Parse(tmpl); err != nil {
+		return nil, errors.Wrapf(err, "invalid JSONPath '%s'", path)
+	}
+	return jp, nil
+}
+
+// conditionStatus returns the expected status, which defaults to 'True'
+func conditionStatus(chk ComponentAction) string {
+	if chk.Status == "" {
+		return "True"
+	}
+	return chk.Status
+}
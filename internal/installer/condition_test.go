@@ -0,0 +1,115 @@
+package installer_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+
+	"github.com/epinio/installer/internal/installer"
+)
+
+func certificate(name string, ready string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cert-manager.io/v1",
+		"kind":       "Certificate",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": "epinio",
+			"labels":    map[string]interface{}{"app.kubernetes.io/part-of": "epinio"},
+		},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Ready", "status": ready},
+			},
+		},
+	}}
+	return obj
+}
+
+func policy(timeout time.Duration) installer.WaitPolicy {
+	return installer.WaitPolicy{Timeout: timeout, Interval: 10 * time.Millisecond}
+}
+
+var _ = Describe("ConditionClient", func() {
+	var mapper *meta.DefaultRESTMapper
+	var chk installer.ComponentAction
+
+	certificates := schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}
+
+	newClient := func(objs ...runtime.Object) *installer.ConditionClient {
+		dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+			certificates: "CertificateList",
+		}, objs...)
+		return installer.NewConditionClient(dyn, mapper)
+	}
+
+	BeforeEach(func() {
+		mapper = meta.NewDefaultRESTMapper(nil)
+		mapper.Add(schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}, meta.RESTScopeNamespace)
+
+		chk = installer.ComponentAction{
+			Type:       installer.Condition,
+			APIVersion: "cert-manager.io/v1",
+			Kind:       "Certificate",
+			Name:       "epinio-tls",
+			Condition:  "Ready",
+		}
+	})
+
+	It("waits for a named object's condition", func() {
+		cc := newClient(certificate("epinio-tls", "True"))
+		Expect(cc.Wait(context.TODO(), "epinio", chk, policy(time.Second))).To(Succeed())
+	})
+
+	It("waits for all objects matching the selector", func() {
+		chk.Name = ""
+		chk.Selector = "app.kubernetes.io/part-of=epinio"
+
+		cc := newClient(certificate("epinio-tls", "True"), certificate("registry-tls", "True"))
+		Expect(cc.Wait(context.TODO(), "epinio", chk, policy(time.Second))).To(Succeed())
+
+		cc = newClient(certificate("epinio-tls", "True"), certificate("registry-tls", "False"))
+		err := cc.Wait(context.TODO(), "epinio", chk, policy(100*time.Millisecond))
+		Expect(err).To(MatchError(ContainSubstring("Certificate 'registry-tls': condition Ready is False, waiting for True")))
+	})
+
+	It("compares the result of a JSONPath", func() {
+		chk.Condition = ""
+		chk.JSONPath = `.status.conditions[?(@.type=="Ready")].status`
+		chk.Value = "True"
+
+		cc := newClient(certificate("epinio-tls", "True"))
+		Expect(cc.Wait(context.TODO(), "epinio", chk, policy(time.Second))).To(Succeed())
+	})
+
+	It("times out for missing objects and kinds", func() {
+		cc := newClient()
+		err := cc.Wait(context.TODO(), "epinio", chk, policy(100*time.Millisecond))
+		Expect(err).To(MatchError(ContainSubstring("no matching objects")))
+
+		chk.Kind = "Issuer"
+		err = cc.Wait(context.TODO(), "epinio", chk, policy(100*time.Millisecond))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("checks once without waiting", func() {
+		cc := newClient(certificate("epinio-tls", "False"))
+		ok, msg, err := cc.Check(context.TODO(), "epinio", chk)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ok).To(BeFalse())
+		Expect(msg).To(Equal("Certificate 'epinio-tls': condition Ready is False, waiting for True"))
+
+		ok, msg, err = newClient().Check(context.TODO(), "epinio", chk)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ok).To(BeFalse())
+		Expect(msg).To(Equal("no matching objects"))
+	})
+})
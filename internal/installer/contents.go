@@ -0,0 +1,130 @@
+package installer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// contents are the digests of the files a component is installed from,
+// so its hash changes with them and not only with their paths
+type contents struct {
+	Source      string   `json:"source,omitempty"`
+	ValuesFiles []string `json:"values_files,omitempty"`
+	Jobs        []string `json:"jobs,omitempty"`
+
+	// Vars are the variables used by the templates
+	Vars Variables `json:"vars,omitempty"`
+}
+
+// ReadContents reads the files each component is installed from, its
+// local or git chart, YAML source, values files and hook jobs, once.
+// Hash and Definition include their digests afterwards, without reading
+// any files themselves. Sources from URLs and git are fetched with ctx.
+func (cs Components) ReadContents(ctx context.Context, sources *Sources) error {
+	for i, c := range cs {
+		ct, err := readContents(ctx, sources, c)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read the files of component '%s'", c.ID)
+		}
+		cs[i].contents = ct
+	}
+	return nil
+}
+
+func readContents(ctx context.Context, sources *Sources, c Component) (*contents, error) {
+	ct := &contents{}
+	templates := [][]byte{}
+
+	switch c.Type {
+	case YAML:
+		data, err := sources.read(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		ct.Source = sha256Hex(data)
+		if c.isTemplate() {
+			templates = append(templates, data)
+		}
+
+	case Helm:
+		// charts from repositories and registries are pinned by their version
+		if c.Source.IsPath() || c.Source.IsGit() {
+			path, err := sources.path(ctx, c)
+			if err != nil {
+				return nil, err
+			}
+			local, err := sources.local(path)
+			if err != nil {
+				return nil, err
+			}
+			if ct.Source, err = digestPath(local); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, path := range c.ValuesFiles {
+		data, err := sources.readFile(path)
+		if err != nil {
+			return nil, err
+		}
+		ct.ValuesFiles = append(ct.ValuesFiles, sha256Hex(data))
+	}
+
+	for _, phase := range []HookPhase{PreInstall, PostInstall, PreUninstall, PostUninstall} {
+		for _, hook := range c.hooks(phase) {
+			if hook.Job == "" {
+				continue
+			}
+			data, err := sources.readFile(hook.Job)
+			if err != nil {
+				return nil, err
+			}
+			ct.Jobs = append(ct.Jobs, sha256Hex(data))
+			templates = append(templates, data)
+		}
+	}
+
+	ct.Vars = c.templateVars(templates)
+	return ct, nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// digestPath returns the digest of a file, or of the names and contents
+// of all files in a directory
+func digestPath(path string) (string, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, _ = io.WriteString(h, filepath.ToSlash(rel)+"\x00")
+		_, err = io.Copy(h, f)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,302 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// diagnosticsTimeout limits collecting diagnostics, the check's context is usually expired already
+	diagnosticsTimeout = 30 * time.Second
+	// diagnosticsEvents is the number of recent events reported per object
+	diagnosticsEvents = 10
+	// diagnosticsLogLines is the number of log lines reported per container
+	diagnosticsLogLines = int64(20)
+)
+
+// DiagnosedError is the error of a failed check, with a report about the
+// state of the objects the check waited for
+type DiagnosedError struct {
+	Err    error
+	Report string
+}
+
+func (e *DiagnosedError) Error() string {
+	return fmt.Sprintf("%v\n%s", e.Err, strings.TrimRight(indent(e.Report, "  "), "\n"))
+}
+
+func (e *DiagnosedError) Unwrap() error {
+	return e.Err
+}
+
+// withoutReport returns the message of err, without the report of a
+// DiagnosedError it wraps. Wrapping errors prefix their message, so the
+// report's lines are the last ones, even if the message was redacted.
+func withoutReport(err error) string {
+	msg := err.Error()
+	de := &DiagnosedError{}
+	if !errors.As(err, &de) {
+		return msg
+	}
+
+	n := strings.Count(indent(de.Report, "  "), "\n")
+	lines := strings.Split(strings.TrimRight(msg, "\n"), "\n")
+	if n == 0 {
+		return strings.Join(lines, "\n")
+	}
+	if len(lines) <= n {
+		return de.Err.Error()
+	}
+	return strings.Join(lines[:len(lines)-n], "\n")
+}
+
+// Diagnostics collects pod phases, container statuses, recent events and
+// log tails, when a check fails
+type Diagnostics struct {
+	client kubernetes.Interface
+
+	// dir stores a report file per failed check, if not empty
+	dir string
+
+	// redactor removes secrets from the report files, if not nil
+	redactor *Redactor
+}
+
+// NewDiagnostics returns a collector, which also writes its reports to
+// dir, unless it is empty
+func NewDiagnostics(client kubernetes.Interface, dir string, redactor *Redactor) *Diagnostics {
+	return &Diagnostics{
+		client:   client,
+		dir:      dir,
+		redactor: redactor,
+	}
+}
+
+// Diagnose returns err with a report about the check's objects. The
+// report is also written to the diagnostics dir. Checks, whose context
+// ctx was cancelled, e.g. because another component failed, aren't
+// diagnosed.
+func (d *Diagnostics) Diagnose(ctx context.Context, c Component, chk ComponentAction, err error) error {
+	if d == nil || err == nil || ctx.Err() == context.Canceled {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), diagnosticsTimeout)
+	defer cancel()
+
+	report := d.Collect(ctx, checkNamespace(c, chk), chk)
+	if d.dir != "" {
+		path, werr := d.write(c, chk, report)
+		if werr != nil {
+			report += fmt.Sprintf("failed to write diagnostics: %v\n", werr)
+		} else {
+			report += fmt.Sprintf("diagnostics written to '%s'\n", path)
+		}
+	}
+	return &DiagnosedError{Err: err, Report: report}
+}
+
+// Collect returns a report about the pods the check is waiting for, or
+// the events of the checked object
+func (d *Diagnostics) Collect(ctx context.Context, namespace string, chk ComponentAction) string {
+	var b strings.Builder
+
+	selector, err := d.podSelector(ctx, namespace, chk)
+	if err != nil {
+		fmt.Fprintf(&b, "failed to find pods: %v\n", err)
+	}
+
+	events, err := d.client.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		fmt.Fprintf(&b, "failed to list events: %v\n", err)
+		events = &v1.EventList{}
+	}
+
+	if selector == "" {
+		name := chk.Selector
+		if chk.Type == Condition {
+			name = chk.Name
+		}
+		if name != "" {
+			writeEvents(&b, events.Items, name, "")
+		}
+		return b.String()
+	}
+
+	pods, err := d.client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		fmt.Fprintf(&b, "failed to list pods: %v\n", err)
+		return b.String()
+	}
+
+	if chk.Type != Pod {
+		writeEvents(&b, events.Items, chk.Selector, "")
+	}
+	fmt.Fprintf(&b, "%d pod(s) with selector '%s' in namespace '%s'\n", len(pods.Items), selector, namespace)
+	for _, pod := range pods.Items {
+		writePod(&b, pod)
+		writeEvents(&b, events.Items, pod.Name, "  ")
+		d.writeLogs(ctx, &b, pod)
+	}
+	return b.String()
+}
+
+// podSelector returns the label selector of the pods, which the check
+// waits for, or an empty string if it doesn't wait for pods
+func (d *Diagnostics) podSelector(ctx context.Context, namespace string, chk ComponentAction) (string, error) {
+	var selector *metav1.LabelSelector
+	switch chk.Type {
+	case Pod:
+		return chk.Selector, nil
+	case Job:
+		return "job-name=" + chk.Selector, nil
+	case Deployment:
+		o, err := d.client.AppsV1().Deployments(namespace).Get(ctx, chk.Selector, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		selector = o.Spec.Selector
+	case StatefulSet:
+		o, err := d.client.AppsV1().StatefulSets(namespace).Get(ctx, chk.Selector, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		selector = o.Spec.Selector
+	case DaemonSet:
+		o, err := d.client.AppsV1().DaemonSets(namespace).Get(ctx, chk.Selector, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		selector = o.Spec.Selector
+	default:
+		return "", nil
+	}
+
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return "", err
+	}
+	return s.String(), nil
+}
+
+func writePod(b *strings.Builder, pod v1.Pod) {
+	fmt.Fprintf(b, "pod '%s': %s", pod.Name, pod.Status.Phase)
+	if pod.Status.Reason != "" {
+		fmt.Fprintf(b, " (%s: %s)", pod.Status.Reason, pod.Status.Message)
+	}
+	fmt.Fprintln(b)
+
+	statuses := append(append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, cs := range statuses {
+		fmt.Fprintf(b, "  container '%s': %s, ready %t, restarts %d\n", cs.Name, containerState(cs.State), cs.Ready, cs.RestartCount)
+		if cs.LastTerminationState.Terminated != nil {
+			fmt.Fprintf(b, "    last %s\n", containerState(cs.LastTerminationState))
+		}
+	}
+}
+
+// containerState describes the state, e.g. 'waiting (CrashLoopBackOff: ...)'
+func containerState(s v1.ContainerState) string {
+	switch {
+	case s.Waiting != nil:
+		return fmt.Sprintf("waiting (%s: %s)", s.Waiting.Reason, s.Waiting.Message)
+	case s.Terminated != nil:
+		return fmt.Sprintf("terminated with exit code %d (%s: %s)", s.Terminated.ExitCode, s.Terminated.Reason, s.Terminated.Message)
+	case s.Running != nil:
+		return "running"
+	}
+	return "unknown"
+}
+
+// writeEvents writes the most recent events of the named object
+func writeEvents(b *strings.Builder, events []v1.Event, name string, prefix string) {
+	matched := []v1.Event{}
+	for _, e := range events {
+		if e.InvolvedObject.Name == name {
+			matched = append(matched, e)
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		return eventTime(matched[i]).Before(eventTime(matched[j]))
+	})
+	if len(matched) > diagnosticsEvents {
+		matched = matched[len(matched)-diagnosticsEvents:]
+	}
+
+	fmt.Fprintf(b, "%sevents of %s '%s':\n", prefix, matched[0].InvolvedObject.Kind, name)
+	for _, e := range matched {
+		fmt.Fprintf(b, "%s  %s %s: %s\n", prefix, e.Type, e.Reason, strings.TrimSpace(e.Message))
+	}
+}
+
+func eventTime(e v1.Event) time.Time {
+	if !e.LastTimestamp.IsZero() {
+		return e.LastTimestamp.Time
+	}
+	if !e.EventTime.IsZero() {
+		return e.EventTime.Time
+	}
+	return e.CreationTimestamp.Time
+}
+
+// writeLogs writes the tail of each container's log
+func (d *Diagnostics) writeLogs(ctx context.Context, b *strings.Builder, pod v1.Pod) {
+	containers := append(append([]v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, c := range containers {
+		tail := diagnosticsLogLines
+		logs, err := d.client.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &v1.PodLogOptions{
+			Container: c.Name,
+			TailLines: &tail,
+		}).DoRaw(ctx)
+		if err != nil {
+			fmt.Fprintf(b, "  no logs of container '%s': %v\n", c.Name, err)
+			continue
+		}
+		if len(logs) == 0 {
+			continue
+		}
+		fmt.Fprintf(b, "  logs of container '%s':\n%s", c.Name, indent(string(logs), "    "))
+	}
+}
+
+var unsafeChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// write stores the report as '<dir>/<component>/<check type>-<selector>.txt'
+func (d *Diagnostics) write(c Component, chk ComponentAction, report string) (string, error) {
+	dir := filepath.Join(d.dir, string(c.ID))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	object := chk.Selector
+	if chk.Type == Condition {
+		object = chk.Kind + "-" + chk.Name + chk.Selector
+	}
+	name := unsafeChars.ReplaceAllString(fmt.Sprintf("%s-%s", chk.Type, object), "_") + ".txt"
+	path := filepath.Join(dir, name)
+	return path, os.WriteFile(path, []byte(d.redactor.Redact(report)), 0644)
+}
+
+// indent prefixes each line of s, the result ends with a newline
+func indent(s string, prefix string) string {
+	s = strings.TrimRight(s, "\n")
+	if s == "" {
+		return ""
+	}
+	return prefix + strings.ReplaceAll(s, "\n", "\n"+prefix) + "\n"
+}
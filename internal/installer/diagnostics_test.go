@@ -0,0 +1,150 @@
+package installer_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/epinio/installer/internal/installer"
+)
+
+var _ = Describe("Diagnostics", func() {
+	var (
+		client *fake.Clientset
+		c      installer.Component
+	)
+
+	BeforeEach(func() {
+		c = installer.Component{ID: "epinio", Namespace: "epinio"}
+		now := metav1.NewTime(time.Now())
+		client = fake.NewSimpleClientset(
+			&appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "server", Namespace: "epinio"},
+				Spec: appsv1.DeploymentSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "server"}},
+				},
+			},
+			&v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "server-1", Namespace: "epinio", Labels: map[string]string{"app": "server"}},
+				Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "server"}}},
+				Status: v1.PodStatus{
+					Phase: v1.PodRunning,
+					ContainerStatuses: []v1.ContainerStatus{{
+						Name:         "server",
+						RestartCount: 4,
+						State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{
+							Reason:  "CrashLoopBackOff",
+							Message: "back-off 1m20s restarting failed container",
+						}},
+						LastTerminationState: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{
+							ExitCode: 1,
+							Reason:   "Error",
+						}},
+					}},
+				},
+			},
+			&v1.Event{
+				ObjectMeta:     metav1.ObjectMeta{Name: "server-1.1", Namespace: "epinio"},
+				InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: "server-1"},
+				Type:           "Warning",
+				Reason:         "BackOff",
+				Message:        "Back-off restarting failed container",
+				LastTimestamp:  now,
+			},
+			&v1.Event{
+				ObjectMeta:     metav1.ObjectMeta{Name: "other.1", Namespace: "epinio"},
+				InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: "other"},
+				Type:           "Normal",
+				Reason:         "Pulled",
+				LastTimestamp:  now,
+			},
+		)
+	})
+
+	Describe("Collect", func() {
+		It("reports pod status, events and logs of a pod check", func() {
+			d := installer.NewDiagnostics(client, "", installer.NewRedactor())
+			report := d.Collect(context.TODO(), "epinio", installer.ComponentAction{Type: installer.Pod, Selector: "app=server"})
+
+			Expect(report).To(ContainSubstring("1 pod(s) with selector 'app=server' in namespace 'epinio'"))
+			Expect(report).To(ContainSubstring("pod 'server-1': Running"))
+			Expect(report).To(ContainSubstring("container 'server': waiting (CrashLoopBackOff: back-off 1m20s restarting failed container), ready false, restarts 4"))
+			Expect(report).To(ContainSubstring("last terminated with exit code 1 (Error: )"))
+			Expect(report).To(ContainSubstring("Warning BackOff: Back-off restarting failed container"))
+			Expect(report).To(ContainSubstring("logs of container 'server':\n    fake logs"))
+			Expect(report).ToNot(ContainSubstring("Pulled"))
+		})
+
+		It("finds the pods of a deployment by its selector", func() {
+			d := installer.NewDiagnostics(client, "", installer.NewRedactor())
+			report := d.Collect(context.TODO(), "epinio", installer.ComponentAction{Type: installer.Deployment, Selector: "server"})
+
+			Expect(report).To(ContainSubstring("1 pod(s) with selector 'app=server' in namespace 'epinio'"))
+			Expect(report).To(ContainSubstring("pod 'server-1'"))
+		})
+
+		It("reports a missing deployment", func() {
+			d := installer.NewDiagnostics(client, "", installer.NewRedactor())
+			report := d.Collect(context.TODO(), "epinio", installer.ComponentAction{Type: installer.Deployment, Selector: "missing"})
+
+			Expect(report).To(ContainSubstring("failed to find pods"))
+		})
+	})
+
+	Describe("Diagnose", func() {
+		chk := installer.ComponentAction{Type: installer.Pod, Selector: "app=server"}
+
+		It("returns the error with the report", func() {
+			d := installer.NewDiagnostics(client, "", installer.NewRedactor())
+			cause := errors.New("timed out waiting for the condition")
+			err := d.Diagnose(context.TODO(), c, chk, cause)
+
+			Expect(errors.Is(err, cause)).To(BeTrue())
+			Expect(err.Error()).To(HavePrefix("timed out waiting for the condition\n  1 pod(s)"))
+		})
+
+		It("writes a redacted report to the diagnostics dir", func() {
+			dir, err := os.MkdirTemp("", "diagnostics")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			redactor := installer.NewRedactor()
+			redactor.Add("fake logs")
+			d := installer.NewDiagnostics(client, dir, redactor)
+			err = d.Diagnose(context.TODO(), c, chk, errors.New("failed"))
+
+			path := filepath.Join(dir, "epinio", "pod-app_server.txt")
+			Expect(err.Error()).To(ContainSubstring("diagnostics written to '" + path + "'"))
+			b, err := os.ReadFile(path)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(b)).To(ContainSubstring("pod 'server-1'"))
+			Expect(string(b)).To(ContainSubstring(installer.Redacted))
+			Expect(string(b)).ToNot(ContainSubstring("fake logs"))
+		})
+
+		It("passes through errors of checks, whose context was canceled", func() {
+			d := installer.NewDiagnostics(client, "", installer.NewRedactor())
+			ctx, cancel := context.WithCancel(context.TODO())
+			cancel()
+
+			cause := errors.New("timed out waiting for the condition")
+			Expect(d.Diagnose(ctx, c, chk, cause)).To(Equal(cause))
+			Expect(d.Diagnose(ctx, c, chk, context.Canceled)).To(Equal(context.Canceled))
+		})
+
+		It("is disabled without diagnostics", func() {
+			var d *installer.Diagnostics
+			cause := errors.New("failed")
+			Expect(d.Diagnose(context.TODO(), c, chk, cause)).To(Equal(cause))
+		})
+	})
+})
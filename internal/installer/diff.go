@@ -0,0 +1,427 @@
+package installer
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/epinio/installer/internal/kubernetes"
+)
+
+type ChangeType string
+
+const (
+	// Added is in the manifest, but not in the cluster
+	Added ChangeType = "added"
+	// Changed differs between the manifest and the cluster
+	Changed ChangeType = "changed"
+	// Removed is in the cluster, but no longer in the manifest
+	Removed ChangeType = "removed"
+
+	// none is shown for missing fields and values
+	none = "<none>"
+)
+
+// Change is a difference between the manifest and the cluster. Details
+// are written as 'path: cluster -> manifest'.
+type Change struct {
+	Type     ChangeType `json:"type"`
+	Resource string     `json:"resource"`
+	Details  []string   `json:"details,omitempty"`
+}
+
+// ComponentDiff lists the changes, which installing the component would make
+type ComponentDiff struct {
+	ID      DeploymentID  `json:"id"`
+	Type    ComponentType `json:"type"`
+	Changes []Change      `json:"changes"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// DiffReport lists the differences of all components, in manifest order
+type DiffReport []ComponentDiff
+
+// HasChanges is true if any component differs, or couldn't be compared
+func (r DiffReport) HasChanges() bool {
+	for _, d := range r {
+		if len(d.Changes) > 0 || d.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteText writes the changes of each component, prefixed by '+' for
+// added, '~' for changed and '-' for removed resources
+func (r DiffReport) WriteText(w io.Writer) error {
+	prefix := map[ChangeType]string{Added: "+", Changed: "~", Removed: "-"}
+	for _, d := range r {
+		fmt.Fprintf(w, "# component '%s' (%s)", d.ID, d.Type)
+		switch {
+		case d.Error != "":
+			fmt.Fprintf(w, ": %s\n", d.Error)
+			continue
+		case len(d.Changes) == 0:
+			fmt.Fprintln(w, ": no changes")
+			continue
+		}
+		fmt.Fprintln(w)
+		for _, c := range d.Changes {
+			fmt.Fprintf(w, "%s %s\n", prefix[c.Type], c.Resource)
+			for _, detail := range c.Details {
+				fmt.Fprintf(w, "    %s\n", detail)
+			}
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes the report as a JSON object
+func (r DiffReport) WriteJSON(w io.Writer) error {
+	components := r
+	if components == nil {
+		components = DiffReport{}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Changed    bool       `json:"changed"`
+		Components DiffReport `json:"components"`
+	}{r.HasChanges(), components})
+}
+
+// Differ compares the manifest with the cluster: rendered YAML with the
+// live objects, and the desired helm chart and values with the deployed
+// release
+type Differ struct {
+	cluster  *kubernetes.Cluster
+	log      logr.Logger
+	helm     *HelmClient
+	yaml     *YAMLClient
+	resolver *Resolver
+	state    State
+}
+
+// NewDiffer returns a differ, the component's files are read from sources
+// and values with a source are read by the resolver. The objects recorded in the state are used to find objects,
+// which were removed from YAML sources.
+func NewDiffer(cluster *kubernetes.Cluster, log logr.Logger, sources *Sources, resolver *Resolver, state State) *Differ {
+	return &Differ{
+		cluster:  cluster,
+		log:      log,
+		helm:     NewHelmClient(cluster, sources),
+		yaml:     NewYAMLClient(cluster.Dynamic, cluster.Mapper, sources),
+		resolver: resolver,
+		state:    state,
+	}
+}
+
+// Report returns the differences of all components
+func (d *Differ) Report(ctx context.Context, components Components) DiffReport {
+	report := DiffReport{}
+	for _, c := range components {
+		report = append(report, d.Diff(ctx, c))
+	}
+	return report
+}
+
+// Diff returns the differences of the component. Resolved secrets are
+// redacted.
+func (d *Differ) Diff(ctx context.Context, c Component) ComponentDiff {
+	cd := ComponentDiff{ID: c.ID, Type: c.Type, Changes: []Change{}}
+
+	changes, err := d.changes(ctx, c)
+	redactor := d.resolver.redactor
+	if err != nil {
+		cd.Error = redactor.Redact(err.Error())
+		return cd
+	}
+
+	for _, change := range changes {
+		for i := range change.Details {
+			change.Details[i] = redactor.Redact(change.Details[i])
+		}
+		cd.Changes = append(cd.Changes, change)
+	}
+	return cd
+}
+
+func (d *Differ) changes(ctx context.Context, c Component) ([]Change, error) {
+	c, err := d.resolver.Resolve(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.Type {
+	case Helm:
+		return d.helm.Diff(ctx, d.log.V(1).WithName("helm"), c)
+	case YAML:
+		return d.yaml.Diff(ctx, c, d.state[c.ID].Objects)
+	case Namespace:
+		return d.namespaceDiff(ctx, c)
+	}
+	return nil, fmt.Errorf("unknown component type '%s'", c.Type)
+}
+
+// namespaceDiff compares the namespace's labels and annotations, other
+// labels and annotations are kept by install
+func (d *Differ) namespaceDiff(ctx context.Context, c Component) ([]Change, error) {
+	resource := fmt.Sprintf("Namespace '%s'", c.Namespace)
+	ns, err := d.cluster.GetNamespace(ctx, c.Namespace)
+	if apierrors.IsNotFound(err) {
+		return []Change{{Type: Added, Resource: resource}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	labels, annotations := namespaceMeta(c)
+	details := diffStrings("metadata.labels", labels, ns.Labels)
+	details = append(details, diffStrings("metadata.annotations", annotations, ns.Annotations)...)
+	if len(details) == 0 {
+		return []Change{}, nil
+	}
+	return []Change{{Type: Changed, Resource: resource, Details: details}}, nil
+}
+
+// diffStrings describes the desired entries, which differ from the actual ones
+func diffStrings(path string, desired, actual map[string]string) []string {
+	details := []string{}
+	for _, k := range sortedKeys(desired) {
+		a, ok := actual[k]
+		if !ok {
+			details = append(details, fmt.Sprintf("%s.%s: %s -> %s", path, k, none, formatValue(desired[k])))
+		} else if a != desired[k] {
+			details = append(details, fmt.Sprintf("%s.%s: %s -> %s", path, k, formatValue(a), formatValue(desired[k])))
+		}
+	}
+	return details
+}
+
+// diffObjectLists matches objects by kind, namespace and name. Objects
+// only found in actual are reported as removed, if all is true.
+func diffObjectLists(desired, actual []*unstructured.Unstructured, all bool) []Change {
+	live := map[string]*unstructured.Unstructured{}
+	for _, obj := range actual {
+		live[objectName(obj)] = obj
+	}
+
+	changes := []Change{}
+	seen := map[string]bool{}
+	for _, obj := range desired {
+		name := objectName(obj)
+		seen[name] = true
+		a, ok := live[name]
+		if !ok {
+			changes = append(changes, Change{Type: Added, Resource: name})
+			continue
+		}
+		if details := diffObject(obj, a, all); len(details) > 0 {
+			changes = append(changes, Change{Type: Changed, Resource: name, Details: details})
+		}
+	}
+
+	if all {
+		for _, obj := range actual {
+			if name := objectName(obj); !seen[name] {
+				changes = append(changes, Change{Type: Removed, Resource: name})
+			}
+		}
+	}
+	return changes
+}
+
+// diffObject describes the fields of desired, which differ from actual.
+// Unless all is true, fields only found in actual are ignored, as they
+// are usually defaults set by the API server. Of the metadata only labels
+// and annotations are compared, the status is ignored.
+func diffObject(desired, actual *unstructured.Unstructured, all bool) []string {
+	strip := func(obj map[string]interface{}) map[string]interface{} {
+		out := map[string]interface{}{}
+		for k, v := range obj {
+			if k == "status" {
+				continue
+			}
+			out[k] = v
+		}
+		meta := map[string]interface{}{}
+		for _, k := range []string{"labels", "annotations"} {
+			if v, ok, _ := unstructured.NestedFieldNoCopy(obj, "metadata", k); ok {
+				meta[k] = v
+			}
+		}
+		out["metadata"] = meta
+		return out
+	}
+
+	secret := desired.GetKind() == "Secret"
+	if secret {
+		desired = foldStringData(desired)
+	}
+
+	details := []string{}
+	diffFields("", strip(desired.Object), strip(actual.Object), all, &details)
+
+	// don't show the content of secrets
+	if secret {
+		for i, d := range details {
+			path := d[:strings.Index(d, ":")]
+			if isSecretData(path) {
+				details[i] = path + ": changed"
+			}
+		}
+	}
+	return details
+}
+
+// foldStringData returns a copy of the secret with its stringData merged
+// into data, base64 encoded, like the API server stores it. Keys in
+// stringData take precedence.
+func foldStringData(secret *unstructured.Unstructured) *unstructured.Unstructured {
+	stringData, ok, _ := unstructured.NestedStringMap(secret.Object, "stringData")
+	if !ok {
+		return secret
+	}
+
+	out := secret.DeepCopy()
+	data, _, _ := unstructured.NestedMap(out.Object, "data")
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	for k, v := range stringData {
+		data[k] = base64.StdEncoding.EncodeToString([]byte(v))
+	}
+	unstructured.RemoveNestedField(out.Object, "stringData")
+	_ = unstructured.SetNestedMap(out.Object, data, "data")
+	return out
+}
+
+// isSecretData is true for the path of a secret's data or stringData,
+// or of any of their entries
+func isSecretData(path string) bool {
+	for _, field := range []string{"data", "stringData"} {
+		if path == field || strings.HasPrefix(path, field+".") || strings.HasPrefix(path, field+"[") {
+			return true
+		}
+	}
+	return false
+}
+
+// diffFields appends 'path: actual -> desired' for each differing field.
+// Maps are compared key by key and lists of the same length item by item.
+func diffFields(path string, desired, actual interface{}, all bool, details *[]string) {
+	join := func(k string) string {
+		if path == "" {
+			return k
+		}
+		return path + "." + k
+	}
+
+	dm, dok := desired.(map[string]interface{})
+	am, aok := actual.(map[string]interface{})
+	if dok && aok {
+		for _, k := range sortedFields(dm) {
+			if av, ok := am[k]; ok {
+				diffFields(join(k), dm[k], av, all, details)
+			} else {
+				*details = append(*details, fmt.Sprintf("%s: %s -> %s", join(k), none, formatValue(dm[k])))
+			}
+		}
+		if all {
+			for _, k := range sortedFields(am) {
+				if _, ok := dm[k]; !ok {
+					*details = append(*details, fmt.Sprintf("%s: %s -> %s", join(k), formatValue(am[k]), none))
+				}
+			}
+		}
+		return
+	}
+
+	dl, dok := desired.([]interface{})
+	al, aok := actual.([]interface{})
+	if dok && aok && len(dl) == len(al) {
+		for i := range dl {
+			diffFields(fmt.Sprintf("%s[%d]", path, i), dl[i], al[i], all, details)
+		}
+		return
+	}
+
+	if d, a := formatValue(desired), formatValue(actual); d != a {
+		*details = append(*details, fmt.Sprintf("%s: %s -> %s", path, a, d))
+	}
+}
+
+// diffValues describes the differences of helm values, keyed by their
+// dotted path like for '--set'. Values from sources, e.g. secrets, are
+// only reported as changed, the deployed ones aren't redacted otherwise.
+func diffValues(desired, actual map[string]interface{}, values Values) []string {
+	d := map[string]interface{}{}
+	flattenValues("", desired, d)
+	a := map[string]interface{}{}
+	flattenValues("", actual, a)
+
+	details := []string{}
+	diffFields("", d, a, true, &details)
+
+	for i, detail := range details {
+		path := detail[:strings.Index(detail, ":")]
+		for _, v := range values {
+			if v.ValueFrom != nil && (path == v.Name || strings.HasPrefix(path, v.Name+".") || strings.HasPrefix(path, v.Name+"[")) {
+				details[i] = path + ": changed"
+				break
+			}
+		}
+	}
+	return details
+}
+
+// flattenValues stores the leaves of the nested values by their dotted path
+func flattenValues(prefix string, vals map[string]interface{}, out map[string]interface{}) {
+	for k, v := range vals {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		if m, ok := v.(map[string]interface{}); ok && len(m) > 0 {
+			flattenValues(path, m, out)
+			continue
+		}
+		out[path] = v
+	}
+}
+
+func sortedFields(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatValue returns the value as compact JSON, so numbers decoded from
+// YAML and JSON compare equal
+func formatValue(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// objectName returns e.g. "ConfigMap 'epinio/config'" or "Namespace 'epinio'"
+func objectName(obj *unstructured.Unstructured) string {
+	if obj.GetNamespace() != "" {
+		return fmt.Sprintf("%s '%s/%s'", obj.GetKind(), obj.GetNamespace(), obj.GetName())
+	}
+	return fmt.Sprintf("%s '%s'", obj.GetKind(), obj.GetName())
+}
@@ -0,0 +1,47 @@
+package installer_test
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/epinio/installer/internal/installer"
+)
+
+var _ = Describe("DiffReport", func() {
+	report := installer.DiffReport{
+		{ID: "epinio-namespace", Type: installer.Namespace, Changes: []installer.Change{}},
+		{ID: "cert-manager", Type: installer.Helm, Changes: []installer.Change{
+			{Type: installer.Changed, Resource: "release 'cert-manager'", Details: []string{"chart: cert-manager-v1.7.0 -> cert-manager-v1.8.0"}},
+			{Type: installer.Added, Resource: "Service 'cert-manager/webhook'"},
+			{Type: installer.Removed, Resource: "ConfigMap 'cert-manager/old'"},
+		}},
+		{ID: "tekton", Type: installer.YAML, Error: "failed to get ConfigMap 'feature-flags'"},
+	}
+
+	It("writes the changes per component", func() {
+		var b bytes.Buffer
+		Expect(report.WriteText(&b)).To(Succeed())
+		Expect(b.String()).To(Equal(`# component 'epinio-namespace' (namespace): no changes
+# component 'cert-manager' (helm)
+~ release 'cert-manager'
+    chart: cert-manager-v1.7.0 -> cert-manager-v1.8.0
++ Service 'cert-manager/webhook'
+- ConfigMap 'cert-manager/old'
+# component 'tekton' (yaml): failed to get ConfigMap 'feature-flags'
+`))
+	})
+
+	It("writes JSON", func() {
+		var b bytes.Buffer
+		Expect(report[:1].WriteJSON(&b)).To(Succeed())
+		Expect(b.String()).To(MatchJSON(`{"changed": false, "components": [{"id": "epinio-namespace", "type": "namespace", "changes": []}]}`))
+	})
+
+	It("has changes if a component differs or failed", func() {
+		Expect(report.HasChanges()).To(BeTrue())
+		Expect(report[:1].HasChanges()).To(BeFalse())
+		Expect(report[2:].HasChanges()).To(BeTrue())
+	})
+})
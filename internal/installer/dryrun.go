@@ -0,0 +1,241 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// DryRun is an action which prints what Install or Uninstall would do,
+// without touching the cluster
+type DryRun struct {
+	out       io.Writer
+	timeout   time.Duration
+	parallel  int
+	uninstall bool
+	sources   *Sources
+	lock      *sync.Mutex
+}
+
+var _ Action = &DryRun{}
+
+// NewDryRun returns an action printing the install steps to out, or the
+// uninstall steps if uninstall is true. Timeout is the default for checks,
+// parallel the limit of the walker, 0 for none. Git sources are checked
+// out and templates read from sources.
+func NewDryRun(out io.Writer, timeout time.Duration, parallel int, uninstall bool, sources *Sources) *DryRun {
+	return &DryRun{
+		out:       out,
+		timeout:   timeout,
+		parallel:  parallel,
+		uninstall: uninstall,
+		sources:   sources,
+		lock:      &sync.Mutex{},
+	}
+}
+
+// Apply prints the steps for the component. Output of a component is not
+// interleaved with others, even if Apply is called in parallel. Values
+// with a source are not resolved, but described.
+func (d DryRun) Apply(ctx context.Context, c Component) error {
+	c = placeholders(c)
+
+	var b strings.Builder
+	c, err := d.checkoutGit(ctx, &b, c)
+	if err == nil {
+		if d.uninstall {
+			err = d.uninstallSteps(ctx, &b, c)
+		} else {
+			err = d.installSteps(ctx, &b, c)
+		}
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	fmt.Fprintf(d.out, "# component '%s' (%s)\n", c.ID, c.Type)
+	fmt.Fprint(d.out, b.String())
+
+	return err
+}
+
+// Walk prints the steps for all components, grouped by the waves in
+// which a Walker with the same parallel limit would run them
+func (d DryRun) Walk(ctx context.Context, components Components) error {
+	var waves []Components
+	var err error
+	if d.uninstall {
+		waves, err = ReverseWaves(components, d.parallel)
+	} else {
+		waves, err = Waves(components, d.parallel)
+	}
+	if err != nil {
+		return err
+	}
+
+	for i, wave := range waves {
+		fmt.Fprintf(d.out, "### wave %d: %s\n", i+1, wave)
+		for _, c := range wave {
+			if err := d.Apply(ctx, c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d DryRun) installSteps(ctx context.Context, w io.Writer, c Component) error {
+	for _, chk := range c.PreDeploy {
+		fmt.Fprintf(w, "pre deploy: %s\n", describeCheck(c, chk, d.timeout))
+	}
+	d.hookSteps(w, c, PreInstall)
+
+	if c.Source.SHA256 != "" && c.Type != Namespace {
+		fmt.Fprintf(w, "verify the sha256 of the source is %s\n", c.Source.SHA256)
+	}
+
+	switch c.Type {
+	case Helm:
+		args, err := helmUpdateArgs(c)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "helm %s\n", shellJoin(args))
+		if len(c.ValuesObject) > 0 {
+			b, err := yaml.Marshal(c.ValuesObject)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "--- values object\n%s---\n", b)
+		}
+
+	case YAML:
+		if err := d.yamlSteps(ctx, w, "apply", c); err != nil {
+			return err
+		}
+
+	case Namespace:
+		labels, annotations := namespaceMeta(c)
+		fmt.Fprintf(w, "create or update namespace '%s'\n", c.Namespace)
+		for _, k := range sortedKeys(labels) {
+			fmt.Fprintf(w, "  set label %s=%s\n", k, labels[k])
+		}
+		for _, k := range sortedKeys(annotations) {
+			fmt.Fprintf(w, "  set annotation %s=%s\n", k, annotations[k])
+		}
+	}
+
+	for _, chk := range c.WaitComplete {
+		fmt.Fprintf(w, "wait complete: %s\n", describeCheck(c, chk, d.timeout))
+	}
+	d.hookSteps(w, c, PostInstall)
+
+	if c.Type == Helm && c.RollbackOnFailure {
+		fmt.Fprintf(w, "on failure: roll back release '%s' to its previous revision, or uninstall it\n", c.Source.Name)
+	}
+
+	return nil
+}
+
+func (d DryRun) uninstallSteps(ctx context.Context, w io.Writer, c Component) error {
+	for _, chk := range c.PreDelete {
+		fmt.Fprintf(w, "pre delete: %s\n", describeCheck(c, chk, d.timeout))
+	}
+	d.hookSteps(w, c, PreUninstall)
+
+	switch c.Type {
+	case Helm:
+		fmt.Fprintf(w, "helm %s\n", shellJoin(helmUninstallArgs(c)))
+
+	case YAML:
+		if err := d.yamlSteps(ctx, w, "delete", c); err != nil {
+			return err
+		}
+
+	case Namespace:
+		fmt.Fprintf(w, "delete namespace '%s'\n", c.Namespace)
+	}
+	d.hookSteps(w, c, PostUninstall)
+
+	return nil
+}
+
+// hookSteps prints the component's hooks for the phase
+func (d DryRun) hookSteps(w io.Writer, c Component, phase HookPhase) {
+	for _, h := range c.hooks(phase) {
+		timeout := hookTimeout(h, d.timeout)
+		if len(h.Command) > 0 {
+			fmt.Fprintf(w, "%s hook: run %s for up to %s\n", phase, shellJoin(h.Command), timeout)
+			continue
+		}
+		fmt.Fprintf(w, "%s hook: replace the job from '%s' and wait up to %s for it to complete\n", phase, h.Job, timeout)
+	}
+}
+
+// checkoutGit checks out the component's git source and returns the
+// component with the local path of the checkout as its source
+func (d DryRun) checkoutGit(ctx context.Context, w io.Writer, c Component) (Component, error) {
+	if c.Source.Git == nil {
+		return c, nil
+	}
+	path, err := d.sources.path(ctx, c)
+	if err != nil {
+		return c, err
+	}
+	fmt.Fprintf(w, "git checkout %s into '%s'\n", c.Source.Git, path)
+	c.Source.Path = path
+	c.Source.Git = nil
+	return c, nil
+}
+
+// yamlSteps prints the kubectl invocation, followed by the rendered
+// template if the component is one. Templates from URLs are downloaded
+// to render them.
+func (d DryRun) yamlSteps(ctx context.Context, w io.Writer, verb string, c Component) error {
+	location := c.Source.location()
+	fmt.Fprintf(w, "kubectl %s\n", shellJoin(kubectlArgs(verb, c, location)))
+	if !c.isTemplate() {
+		return nil
+	}
+
+	data, err := d.sources.read(ctx, c)
+	if err != nil {
+		return err
+	}
+	rendered, err := renderTemplate(c, data)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "--- rendered from '%s'\n%s", location, rendered)
+	if !strings.HasSuffix(rendered, "\n") {
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w, "---")
+	return nil
+}
+
+// shellJoin quotes args which are empty or contain whitespace
+func shellJoin(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, a := range args {
+		if a == "" || strings.ContainsAny(a, " \t\n\"'") {
+			a = fmt.Sprintf("%q", a)
+		}
+		quoted = append(quoted, a)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,94 @@
+package installer_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/epinio/installer/internal/installer"
+)
+
+var _ = Describe("DryRun", func() {
+	var m *installer.Manifest
+
+	BeforeEach(func() {
+		var err error
+		m, err = installer.Load(assetPath("dryrun-manifest.yml"))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("prints the install steps in waves", func() {
+		out := &bytes.Buffer{}
+		err := installer.NewDryRun(out, time.Minute, 0, false, nil).Walk(context.TODO(), m.Components)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(out.String()).To(ContainSubstring("### wave 1: epinio-namespace, cert-manager\n"))
+		Expect(out.String()).To(ContainSubstring("### wave 2: cluster-issuers\n"))
+		Expect(out.String()).To(ContainSubstring("  set annotation linkerd.io/inject=enabled\n"))
+		Expect(out.String()).To(ContainSubstring("helm upgrade cert-manager --install --namespace cert-manager --create-namespace --wait --timeout 20m0s --repo https://charts.jetstack.io --version v1.5.4 cert-manager --set installCRDs=true\n"))
+		Expect(out.String()).To(ContainSubstring("wait complete: wait up to 1m30s for pods with selector 'app.kubernetes.io/name=webhook' in namespace 'cert-manager' to be ready\n"))
+		Expect(out.String()).To(MatchRegexp(`wait complete: wait up to \S+ for the rollout of deployment 'cert-manager-webhook' in namespace 'cert-manager'\n`))
+		Expect(out.String()).To(MatchRegexp(`wait complete: wait up to \S+ for namespace 'cert-manager' to exist\n`))
+		Expect(out.String()).To(ContainSubstring("on failure: roll back release 'cert-manager' to its previous revision, or uninstall it\n"))
+		Expect(out.String()).To(MatchRegexp(`wait complete: wait up to \S+ for ClusterIssuer 'letsencrypt-production' to have condition Ready=True\n`))
+		Expect(out.String()).To(ContainSubstring("kubectl apply --server-side --force-conflicts --field-manager epinio-installer --filename ../../assets/tests/cluster-issuer.yaml\n"))
+		Expect(out.String()).To(ContainSubstring("    email: epinio@epinio.io\n"))
+		Expect(out.String()).To(ContainSubstring("postInstall hook: run kubectl get clusterissuers for up to 30s\n"))
+	})
+
+	It("limits the waves to the number of parallel components", func() {
+		out := &bytes.Buffer{}
+		err := installer.NewDryRun(out, time.Minute, 1, false, nil).Walk(context.TODO(), m.Components)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(out.String()).To(ContainSubstring("### wave 1: epinio-namespace\n"))
+		Expect(out.String()).To(ContainSubstring("### wave 2: cert-manager\n"))
+		Expect(out.String()).To(ContainSubstring("### wave 3: cluster-issuers\n"))
+	})
+
+	It("prints the uninstall steps in reverse waves", func() {
+		out := &bytes.Buffer{}
+		err := installer.NewDryRun(out, time.Minute, 0, true, nil).Walk(context.TODO(), m.Components)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(out.String()).To(ContainSubstring("### wave 1: epinio-namespace, cluster-issuers\n"))
+		Expect(out.String()).To(ContainSubstring("### wave 2: cert-manager\n"))
+		Expect(out.String()).To(ContainSubstring("helm uninstall cert-manager --namespace cert-manager --wait --timeout 20m0s\n"))
+		Expect(out.String()).To(ContainSubstring("kubectl delete --wait --ignore-not-found --filename ../../assets/tests/cluster-issuer.yaml\n"))
+		Expect(out.String()).To(ContainSubstring("delete namespace 'epinio'\n"))
+		Expect(out.String()).To(ContainSubstring("preUninstall hook: replace the job from '../../assets/tests/hook-job.yaml' and wait up to 1m0s for it to complete\n"))
+	})
+
+	It("prints values files and the values object", func() {
+		m, err := installer.Load(assetPath("values-manifest.yml"))
+		Expect(err).ToNot(HaveOccurred())
+
+		out := &bytes.Buffer{}
+		err = installer.NewDryRun(out, time.Minute, 0, false, nil).Walk(context.TODO(), m.Components)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(out.String()).To(ContainSubstring("--values ../../assets/tests/values/hello-base.yaml --values ../../assets/tests/values/hello-override.yaml --values - --set greeting=from-values\n"))
+		Expect(out.String()).To(ContainSubstring("--- values object\nenabled: true\n"))
+		Expect(out.String()).To(ContainSubstring("  linkerd.io/inject: enabled\n"))
+	})
+
+	It("prints YAML URLs and checksums", func() {
+		sum := strings.Repeat("a", 64)
+		c := installer.Component{
+			ID:     "tekton",
+			Type:   installer.YAML,
+			Source: installer.Source{URL: "https://example.com/release.yaml", SHA256: sum},
+		}
+
+		out := &bytes.Buffer{}
+		err := installer.NewDryRun(out, time.Minute, 0, false, nil).Walk(context.TODO(), installer.Components{c})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(out.String()).To(ContainSubstring("verify the sha256 of the source is " + sum + "\n"))
+		Expect(out.String()).To(ContainSubstring("kubectl apply --server-side --force-conflicts --field-manager epinio-installer --filename https://example.com/release.yaml\n"))
+	})
+})
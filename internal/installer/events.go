@@ -0,0 +1,76 @@
+package installer
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventQueued       EventType = "queued"
+	EventStarted      EventType = "started"
+	EventCheckStarted EventType = "check_started"
+	EventCheckPassed  EventType = "check_passed"
+	EventCheckFailed  EventType = "check_failed"
+	EventFinished     EventType = "finished"
+	// EventSkipped is emitted for components, which were not started
+	// because another component failed
+	EventSkipped EventType = "skipped"
+)
+
+// Event is a state change of a component or one of its checks
+type Event struct {
+	Time      time.Time    `json:"time"`
+	Type      EventType    `json:"type"`
+	Component DeploymentID `json:"component"`
+
+	Check     string     `json:"check,omitempty"`
+	CheckType ActionType `json:"checkType,omitempty"`
+
+	// Status is 'done' or 'failed' for finished components
+	Status   ComponentStatus `json:"status,omitempty"`
+	Duration Duration        `json:"duration,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// Events writes each event as a single line of JSON, so progress can be
+// consumed by other tools
+type Events struct {
+	out      io.Writer
+	redactor *Redactor
+	lock     *sync.Mutex
+}
+
+// NewEvents returns an event stream writing to out, secrets are redacted
+// from errors, unless the redactor is nil
+func NewEvents(out io.Writer, redactor *Redactor) *Events {
+	return &Events{
+		out:      out,
+		redactor: redactor,
+		lock:     &sync.Mutex{},
+	}
+}
+
+// Emit writes the event, the time defaults to now. Events are dropped if
+// the stream is nil.
+func (e *Events) Emit(ev Event) {
+	if e == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	ev.Error = e.redactor.Redact(ev.Error)
+
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	_, _ = e.out.Write(append(b, '\n'))
+}
@@ -0,0 +1,43 @@
+package installer_test
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/epinio/installer/internal/installer"
+)
+
+var _ = Describe("Events", func() {
+	It("writes an event per line", func() {
+		var b bytes.Buffer
+		events := installer.NewEvents(&b, installer.NewRedactor())
+		at := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+
+		events.Emit(installer.Event{Time: at, Type: installer.EventCheckStarted, Component: "epinio", Check: "pod 'app=server'", CheckType: installer.Pod})
+		events.Emit(installer.Event{Time: at, Type: installer.EventCheckPassed, Component: "epinio", Check: "pod 'app=server'", CheckType: installer.Pod, Duration: installer.Duration(1500 * time.Millisecond)})
+
+		Expect(b.String()).To(Equal(
+			`{"time":"2022-05-01T12:00:00Z","type":"check_started","component":"epinio","check":"pod 'app=server'","checkType":"pod"}` + "\n" +
+				`{"time":"2022-05-01T12:00:00Z","type":"check_passed","component":"epinio","check":"pod 'app=server'","checkType":"pod","duration":"1.5s"}` + "\n"))
+	})
+
+	It("redacts secrets from errors", func() {
+		var b bytes.Buffer
+		redactor := installer.NewRedactor()
+		redactor.Add("s3cr3t")
+		events := installer.NewEvents(&b, redactor)
+
+		events.Emit(installer.Event{Type: installer.EventFinished, Component: "epinio", Status: installer.StatusFailed, Error: errors.New("bad password s3cr3t").Error()})
+		Expect(b.String()).To(ContainSubstring(`"error":"bad password [REDACTED]"`))
+		Expect(b.String()).To(ContainSubstring(`"status":"failed"`))
+	})
+
+	It("drops events without a stream", func() {
+		var events *installer.Events
+		events.Emit(installer.Event{Type: installer.EventQueued, Component: "epinio"})
+	})
+})
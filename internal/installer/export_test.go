@@ -0,0 +1,12 @@
+package installer
+
+// SetHelmClient replaces the install's helm client, e.g. with one for a
+// fake helm configuration
+func (i *Install) SetHelmClient(h *HelmClient) {
+	i.helm = h
+}
+
+// SetHelmClient replaces the status checker's helm client
+func (s *Status) SetHelmClient(h *HelmClient) {
+	s.helm = h
+}
@@ -0,0 +1,185 @@
+package installer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultGitCacheDir is below the user's cache dir, or the temp dir
+func DefaultGitCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "epinio-installer", "git")
+}
+
+// commitRegexp matches full commit hashes, which never have to be fetched
+// again once they are in the cache
+var commitRegexp = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// GitCache keeps a clone of each repository and a checkout of each used
+// commit below its directory. Branches are fetched on every use, tags
+// and commits only if they are missing.
+type GitCache struct {
+	dir string
+
+	// mu serializes the git commands, parallel components might use
+	// the same repository
+	mu sync.Mutex
+}
+
+// NewGitCache returns a cache in dir
+func NewGitCache(dir string) *GitCache {
+	return &GitCache{dir: dir}
+}
+
+// Checkout returns the local path of the source's path, in a checkout of
+// its ref
+func (g *GitCache) Checkout(ctx context.Context, src GitSource) (string, error) {
+	if src.URL == "" {
+		return "", errors.New("git source needs a url")
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(src.URL))
+	key := hex.EncodeToString(sum[:])[:16]
+	repo := filepath.Join(g.dir, key, "repo")
+
+	fetched := false
+	if _, err := os.Stat(repo); os.IsNotExist(err) {
+		if err := clone(ctx, src.URL, repo); err != nil {
+			return "", errors.Wrapf(err, "failed to clone '%s'", src.URL)
+		}
+		fetched = true
+	} else if err != nil {
+		return "", err
+	}
+
+	commit, err := resolvePinned(ctx, repo, src.Ref)
+	if err != nil {
+		// branches move and new tags or commits are missing
+		if !fetched {
+			if _, err := git(ctx, repo, "fetch", "--quiet", "--prune", "--tags", "--force", "origin"); err != nil {
+				return "", errors.Wrapf(err, "failed to fetch '%s'", src.URL)
+			}
+		}
+		commit, err = resolve(ctx, repo, src.Ref)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to find '%s'", src)
+		}
+	}
+
+	checkout := filepath.Join(g.dir, key, commit)
+	if _, err := os.Stat(checkout); os.IsNotExist(err) {
+		if err := addWorktree(ctx, repo, checkout, commit); err != nil {
+			return "", errors.Wrapf(err, "failed to check out '%s'", src)
+		}
+	} else if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(checkout, filepath.FromSlash(src.Path))
+	if path != checkout && !strings.HasPrefix(path, checkout+string(filepath.Separator)) {
+		return "", fmt.Errorf("path '%s' is outside of the repository", src.Path)
+	}
+	return path, nil
+}
+
+// clone clones the repository into a temporary directory, which is
+// renamed to repo when it's complete. An interrupted clone is never used.
+func clone(ctx context.Context, url string, repo string) error {
+	tmp := repo + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(repo), 0700); err != nil {
+		return err
+	}
+	if _, err := git(ctx, "", "clone", "--quiet", "--no-checkout", "--", url, tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, repo)
+}
+
+// addWorktree checks out the commit into a temporary worktree, which is
+// moved to checkout when it's complete, like clone
+func addWorktree(ctx context.Context, repo string, checkout string, commit string) error {
+	tmp := checkout + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	// forget worktrees of interrupted checkouts
+	if _, err := git(ctx, repo, "worktree", "prune"); err != nil {
+		return err
+	}
+	if _, err := git(ctx, repo, "worktree", "add", "--detach", "--force", tmp, commit); err != nil {
+		return err
+	}
+	_, err := git(ctx, repo, "worktree", "move", tmp, checkout)
+	return err
+}
+
+// resolvePinned returns the commit of a tag or a full commit hash, which
+// are in the clone already
+func resolvePinned(ctx context.Context, repo string, ref string) (string, error) {
+	switch {
+	case ref == "":
+		return "", errors.New("HEAD is not pinned")
+	case commitRegexp.MatchString(ref):
+		return revParse(ctx, repo, ref)
+	}
+	return revParse(ctx, repo, "refs/tags/"+ref)
+}
+
+// resolve returns the commit of a branch, tag or commit
+func resolve(ctx context.Context, repo string, ref string) (string, error) {
+	if ref == "" {
+		return revParse(ctx, repo, "refs/remotes/origin/HEAD")
+	}
+	for _, r := range []string{"refs/remotes/origin/" + ref, "refs/tags/" + ref} {
+		if commit, err := revParse(ctx, repo, r); err == nil {
+			return commit, nil
+		}
+	}
+	// abbreviated commit hashes
+	return revParse(ctx, repo, ref)
+}
+
+// revParse returns the commit the ref points to
+func revParse(ctx context.Context, repo string, ref string) (string, error) {
+	out, err := git(ctx, repo, "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("unknown ref '%s'", ref)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// git runs git in dir and returns its output, prompts for credentials
+// are disabled
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(out.String()))
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,194 @@
+package installer_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/go-logr/logr"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chartutil"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+
+	"github.com/epinio/installer/internal/installer"
+)
+
+var _ = Describe("GitCache", func() {
+	var (
+		dir   string
+		repo  string
+		url   string
+		cache *installer.GitCache
+	)
+
+	// git runs git in the test repository and returns its output
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		out, err := cmd.CombinedOutput()
+		Expect(err).ToNot(HaveOccurred(), string(out))
+		return strings.TrimSpace(string(out))
+	}
+
+	// commit writes the greeting to the repository and commits it
+	commit := func(greeting string) string {
+		Expect(ioutil.WriteFile(filepath.Join(repo, "greeting.txt"), []byte(greeting), 0600)).To(Succeed())
+		git("add", "--all")
+		git("commit", "--quiet", "--message", greeting)
+		return git("rev-parse", "HEAD")
+	}
+
+	read := func(path string) string {
+		b, err := ioutil.ReadFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		return string(b)
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "git-test-")
+		Expect(err).ToNot(HaveOccurred())
+
+		repo = filepath.Join(dir, "origin")
+		Expect(os.MkdirAll(filepath.Join(repo, "charts"), 0700)).To(Succeed())
+		out, err := exec.Command("cp", "-r", assetPath("charts/hello"), filepath.Join(repo, "charts")).CombinedOutput()
+		Expect(err).ToNot(HaveOccurred(), string(out))
+
+		git("init", "--quiet", "--initial-branch", "main")
+		commit("hi")
+		git("tag", "v1")
+		url = "file://" + repo
+
+		cache = installer.NewGitCache(filepath.Join(dir, "cache"))
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("ignores interrupted clones and checkouts", func() {
+		_, err := cache.Checkout(context.TODO(), installer.GitSource{URL: url, Ref: "v1", Path: "greeting.txt"})
+		Expect(err).ToNot(HaveOccurred())
+
+		clones, err := filepath.Glob(filepath.Join(dir, "cache", "*", "repo"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(clones).To(HaveLen(1))
+
+		// leftovers of a killed run
+		Expect(os.RemoveAll(filepath.Dir(clones[0]))).To(Succeed())
+		Expect(os.MkdirAll(clones[0]+".tmp", 0700)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(clones[0]+".tmp", "HEAD"), []byte("partial"), 0600)).To(Succeed())
+
+		path, err := cache.Checkout(context.TODO(), installer.GitSource{URL: url, Ref: "v1", Path: "greeting.txt"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(read(path)).To(Equal("hi"))
+		Expect(clones[0] + ".tmp").ToNot(BeADirectory())
+	})
+
+	It("checks out the default branch", func() {
+		path, err := cache.Checkout(context.TODO(), installer.GitSource{URL: url, Path: "greeting.txt"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(read(path)).To(Equal("hi"))
+	})
+
+	It("checks out tags and commits", func() {
+		first := git("rev-parse", "HEAD")
+		commit("hey")
+
+		path, err := cache.Checkout(context.TODO(), installer.GitSource{URL: url, Ref: "v1", Path: "greeting.txt"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(read(path)).To(Equal("hi"))
+
+		path, err = cache.Checkout(context.TODO(), installer.GitSource{URL: url, Ref: first, Path: "greeting.txt"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(read(path)).To(Equal("hi"))
+
+		path, err = cache.Checkout(context.TODO(), installer.GitSource{URL: url, Ref: "main", Path: "greeting.txt"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(read(path)).To(Equal("hey"))
+	})
+
+	It("fetches branches, which moved since they were cached", func() {
+		src := installer.GitSource{URL: url, Ref: "main", Path: "greeting.txt"}
+		_, err := cache.Checkout(context.TODO(), src)
+		Expect(err).ToNot(HaveOccurred())
+
+		commit("hey")
+		path, err := cache.Checkout(context.TODO(), src)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(read(path)).To(Equal("hey"))
+	})
+
+	It("uses cached tags without the repository", func() {
+		src := installer.GitSource{URL: url, Ref: "v1", Path: "greeting.txt"}
+		_, err := cache.Checkout(context.TODO(), src)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(os.RemoveAll(repo)).To(Succeed())
+		path, err := cache.Checkout(context.TODO(), src)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(read(path)).To(Equal("hi"))
+	})
+
+	It("fails for unknown refs and paths outside of the repository", func() {
+		_, err := cache.Checkout(context.TODO(), installer.GitSource{URL: url, Ref: "v9"})
+		Expect(err).To(MatchError(ContainSubstring("failed to find '" + url + "@v9'")))
+
+		_, err = cache.Checkout(context.TODO(), installer.GitSource{URL: url, Path: "../secret"})
+		Expect(err).To(MatchError("path '../secret' is outside of the repository"))
+
+		_, err = cache.Checkout(context.TODO(), installer.GitSource{URL: "file://" + filepath.Join(dir, "missing")})
+		Expect(err).To(MatchError(ContainSubstring("failed to clone")))
+	})
+
+	Context("as sources of components", func() {
+		var sources *installer.Sources
+
+		BeforeEach(func() {
+			sources = installer.NewSources(nil, cache)
+		})
+
+		It("installs charts from git", func() {
+			cfg := &action.Configuration{
+				Releases:     storage.Init(driver.NewMemory()),
+				KubeClient:   &kubefake.PrintingKubeClient{Out: ioutil.Discard},
+				Capabilities: chartutil.DefaultCapabilities,
+			}
+			c := installer.Component{
+				ID:        "hello",
+				Type:      installer.Helm,
+				Namespace: "hello",
+				Source:    installer.Source{Name: "hello", Git: &installer.GitSource{URL: url, Ref: "v1", Path: "charts/hello"}},
+			}
+
+			Expect(installer.NewHelmClientForConfig(cfg, sources).Update(context.TODO(), logr.Discard(), c)).To(Succeed())
+
+			rel, err := cfg.Releases.Last("hello")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rel.Chart.Metadata.Name).To(Equal("hello"))
+		})
+
+		It("prints the checkout in dry runs", func() {
+			c := installer.Component{
+				ID:     "greeting",
+				Type:   installer.YAML,
+				Source: installer.Source{Git: &installer.GitSource{URL: url, Ref: "v1", Path: "greeting.txt"}},
+			}
+
+			out := &bytes.Buffer{}
+			Expect(installer.NewDryRun(out, time.Minute, 0, false, sources).Walk(context.TODO(), installer.Components{c})).To(Succeed())
+			Expect(out.String()).To(MatchRegexp(`git checkout %s@v1 into '(\S+)/greeting.txt'\n`, url))
+			Expect(out.String()).To(MatchRegexp(`kubectl apply .* --filename \S+/greeting.txt\n`))
+		})
+	})
+})
@@ -1,24 +1,460 @@
 package installer
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
-	"github.com/epinio/installer/internal/exec"
 	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"helm.sh/helm/v3/pkg/strvals"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+
+	"github.com/epinio/installer/internal/duration"
+	"github.com/epinio/installer/internal/kubernetes"
 )
 
-// TODO helm sdk
-func helmUpdate(log logr.Logger, c Component) error {
-	currentdir, _ := os.Getwd()
+// HelmClient installs and uninstalls helm components in-process, using the helm SDK
+type HelmClient struct {
+	settings *cli.EnvSettings
+	sources  *Sources
+
+	// config returns the action configuration for a namespace
+	config func(namespace string, log action.DebugLog) (*action.Configuration, error)
+}
+
+// NewHelmClient returns a helm client for the cluster. Releases are
+// stored by the driver in HELM_DRIVER, like the helm CLI does. Local
+// charts and values files are read from sources.
+func NewHelmClient(cluster *kubernetes.Cluster, sources *Sources) *HelmClient {
+	return &HelmClient{
+		settings: cli.New(),
+		sources:  sources,
+		config: func(namespace string, log action.DebugLog) (*action.Configuration, error) {
+			cfg := &action.Configuration{}
+			err := cfg.Init(cluster.RESTClientGetter(namespace), namespace, os.Getenv("HELM_DRIVER"), log)
+			return cfg, err
+		},
+	}
+}
+
+// NewHelmClientForConfig returns a helm client, which uses cfg for all
+// namespaces. This allows using a fake kube client and storage driver.
+func NewHelmClientForConfig(cfg *action.Configuration, sources *Sources) *HelmClient {
+	return &HelmClient{
+		settings: cli.New(),
+		sources:  sources,
+		config: func(namespace string, log action.DebugLog) (*action.Configuration, error) {
+			cfg.Log = log
+			return cfg, nil
+		},
+	}
+}
+
+// Update installs the release, or upgrades it if it exists, like 'helm upgrade --install'
+func (h *HelmClient) Update(ctx context.Context, log logr.Logger, c Component) error {
+	cfg, err := h.config(c.Namespace, debugLog(log))
+	if err != nil {
+		return err
+	}
+
+	chrt, err := h.loadChart(ctx, c)
+	if err != nil {
+		return errors.Wrapf(err, "failed loading chart for %s", c.ID)
+	}
+
+	vals, err := helmValues(h.sources, c)
+	if err != nil {
+		return errors.Wrapf(err, "failed parsing values for %s", c.ID)
+	}
+
+	hist := action.NewHistory(cfg)
+	hist.Max = 1
+	if _, err := hist.Run(c.Source.Name); errors.Is(err, driver.ErrReleaseNotFound) {
+		log.Info("install", "release", c.Source.Name, "chart", chrt.Name(), "version", chrt.Metadata.Version)
+
+		client := action.NewInstall(cfg)
+		client.ReleaseName = c.Source.Name
+		client.Namespace = c.Namespace
+		client.CreateNamespace = true
+		client.Wait = true
+		client.Timeout = helmTimeout(c)
+
+		if _, err := client.RunWithContext(ctx, chrt, vals); err != nil {
+			return errors.Wrapf(err, "failed installing %s", c.ID)
+		}
+	} else if err != nil {
+		return errors.Wrapf(err, "failed reading release history of %s", c.ID)
+	} else {
+		log.Info("upgrade", "release", c.Source.Name, "chart", chrt.Name(), "version", chrt.Metadata.Version)
+
+		client := action.NewUpgrade(cfg)
+		client.Namespace = c.Namespace
+		client.Wait = true
+		client.Timeout = helmTimeout(c)
+
+		if _, err := client.RunWithContext(ctx, c.Source.Name, chrt, vals); err != nil {
+			return errors.Wrapf(err, "failed upgrading %s", c.ID)
+		}
+	}
+
+	log.V(1).Info("done")
+	return nil
+}
+
+// Uninstall removes the release, it's not an error if it doesn't exist
+func (h *HelmClient) Uninstall(ctx context.Context, log logr.Logger, c Component) error {
+	cfg, err := h.config(c.Namespace, debugLog(log))
+	if err != nil {
+		return err
+	}
+
+	log.Info("uninstall", "release", c.Source.Name)
+
+	client := action.NewUninstall(cfg)
+	client.Wait = true
+	client.Timeout = helmTimeout(c)
+	if _, err := client.Run(c.Source.Name); err != nil {
+		if errors.Is(err, driver.ErrReleaseNotFound) {
+			return nil
+		}
+		return errors.Wrapf(err, "failed uninstalling %s", c.ID)
+	}
+
+	// TODO we could delete the namespace if it was created by helm
+
+	log.V(1).Info("done")
+	return nil
+}
+
+// Revision returns the revision of the component's release, 0 if it
+// doesn't exist
+func (h *HelmClient) Revision(log logr.Logger, c Component) (int, error) {
+	cfg, err := h.config(c.Namespace, debugLog(log))
+	if err != nil {
+		return 0, err
+	}
+
+	rel, err := action.NewGet(cfg).Run(c.Source.Name)
+	if errors.Is(err, driver.ErrReleaseNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed reading release of %s", c.ID)
+	}
+	return rel.Version, nil
+}
+
+// Rollback rolls the release back to the revision, or uninstalls it if
+// the revision is 0, like 'helm upgrade --atomic' does
+func (h *HelmClient) Rollback(log logr.Logger, c Component, revision int) error {
+	if revision == 0 {
+		return h.Uninstall(context.Background(), log, c)
+	}
+
+	cfg, err := h.config(c.Namespace, debugLog(log))
+	if err != nil {
+		return err
+	}
+
+	log.Info("rollback", "release", c.Source.Name, "revision", revision)
+
+	client := action.NewRollback(cfg)
+	client.Version = revision
+	client.Wait = true
+	client.Timeout = helmTimeout(c)
+	if err := client.Run(c.Source.Name); err != nil {
+		return errors.Wrapf(err, "failed rolling back %s", c.ID)
+	}
+	return nil
+}
+
+// ReleaseStatus returns the status of the component's release, it's
+// empty if the release doesn't exist
+func (h *HelmClient) ReleaseStatus(ctx context.Context, log logr.Logger, c Component) (release.Status, error) {
+	cfg, err := h.config(c.Namespace, debugLog(log))
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := action.NewStatus(cfg).Run(c.Source.Name)
+	if errors.Is(err, driver.ErrReleaseNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "failed reading release status of %s", c.ID)
+	}
+	return rel.Info.Status, nil
+}
+
+// Diff compares the deployed release with the component's chart and
+// values. The chart is rendered by a dry-run upgrade, to compare the
+// resources.
+func (h *HelmClient) Diff(ctx context.Context, log logr.Logger, c Component) ([]Change, error) {
+	cfg, err := h.config(c.Namespace, debugLog(log))
+	if err != nil {
+		return nil, err
+	}
+
+	chrt, err := h.loadChart(ctx, c)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed loading chart for %s", c.ID)
+	}
+
+	vals, err := helmValues(h.sources, c)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed parsing values for %s", c.ID)
+	}
+
+	deployed, err := action.NewGet(cfg).Run(c.Source.Name)
+	if errors.Is(err, driver.ErrReleaseNotFound) {
+		return []Change{{
+			Type:     Added,
+			Resource: fmt.Sprintf("release '%s'", c.Source.Name),
+			Details:  []string{fmt.Sprintf("chart: %s -> %s", none, chartName(chrt))},
+		}}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed reading release of %s", c.ID)
+	}
+
+	changes := []Change{}
+	name := fmt.Sprintf("release '%s'", c.Source.Name)
+	if from, to := chartName(deployed.Chart), chartName(chrt); from != to {
+		changes = append(changes, Change{Type: Changed, Resource: name, Details: []string{fmt.Sprintf("chart: %s -> %s", from, to)}})
+	}
+	if details := diffValues(vals, deployed.Config, c.Values); len(details) > 0 {
+		changes = append(changes, Change{Type: Changed, Resource: name + " values", Details: details})
+	}
+
+	client := action.NewUpgrade(cfg)
+	client.Namespace = c.Namespace
+	client.DryRun = true
+	desired, err := client.RunWithContext(ctx, c.Source.Name, chrt, vals)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed rendering %s", c.ID)
+	}
+
+	desiredObjs, err := decodeObjects(strings.NewReader(desired.Manifest))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse rendered manifest of %s", c.ID)
+	}
+	deployedObjs, err := decodeObjects(strings.NewReader(deployed.Manifest))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse deployed manifest of %s", c.ID)
+	}
+	setNamespace(desiredObjs, c.Namespace)
+	setNamespace(deployedObjs, c.Namespace)
+
+	return append(changes, diffObjectLists(desiredObjs, deployedObjs, true)...), nil
+}
+
+// chartName returns the chart's name and version, e.g. 'cert-manager-v1.8.0'
+func chartName(chrt *chart.Chart) string {
+	if chrt == nil || chrt.Metadata == nil {
+		return none
+	}
+	return chrt.Metadata.Name + "-" + chrt.Metadata.Version
+}
+
+// setNamespace sets the namespace of objects without one, like helm
+// does for namespaced resources. Objects of cluster-scoped kinds are not
+// affected, as both sides of a diff are treated the same.
+func setNamespace(objs []*unstructured.Unstructured, namespace string) {
+	for _, obj := range objs {
+		if obj.GetNamespace() == "" {
+			obj.SetNamespace(namespace)
+		}
+	}
+}
+
+// loadChart finds the chart from the component's source, downloading it
+// into the helm repository cache if needed. OCI charts are pulled into
+// memory and git sources are checked out. Chart archives are verified,
+// if the source has a checksum.
+func (h *HelmClient) loadChart(ctx context.Context, c Component) (*chart.Chart, error) {
+	if c.Source.IsOCI() {
+		return pullOCIChart(ctx, c.Source)
+	}
+
+	path, err := h.locateChart(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifyFileSHA256(path, c.Source.SHA256); err != nil {
+		return nil, err
+	}
+	return loader.Load(path)
+}
+
+// locateChart returns the local path of the chart archive or directory
+func (h *HelmClient) locateChart(ctx context.Context, c Component) (string, error) {
+	if c.Source.IsGit() {
+		return h.sources.path(ctx, c)
+	}
+
+	opts := action.ChartPathOptions{}
+
+	var name string
+	if c.Source.IsPath() {
+		var err error
+		name, err = h.sources.local(c.Source.Path)
+		if err != nil {
+			return "", err
+		}
+	} else if c.Source.IsURL() {
+		name = c.Source.URL
+	} else if c.Source.IsHelmRef() {
+		opts.RepoURL = c.Source.URL
+		opts.Version = c.Source.Version
+		name = c.Source.Chart
+	} else {
+		return "", errors.New("helm source is incomplete")
+	}
+
+	return opts.LocateChart(name, h.settings)
+}
+
+// helmValues merges the component's values files, its values object and
+// its values, in that order, like 'helm --values ... --set ...' does
+func helmValues(sources *Sources, c Component) (map[string]interface{}, error) {
+	vals := map[string]interface{}{}
+	for _, path := range c.ValuesFiles {
+		local, err := sources.local(path)
+		if err != nil {
+			return nil, err
+		}
+		b, err := os.ReadFile(local)
+		if err != nil {
+			return nil, err
+		}
+		file := map[string]interface{}{}
+		if err := yaml.Unmarshal(b, &file); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse values file '%s'", path)
+		}
+		vals = mergeValues(vals, file)
+	}
+
+	vals = mergeValues(vals, c.ValuesObject)
+
+	set := map[string]interface{}{}
+	literals := map[string]string{}
+	for i, val := range c.Values {
+		if val.ValueFrom == nil {
+			if err := strvals.ParseInto(fmt.Sprintf("%s=%s", val.Name, val.Value), set); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// resolved values are set literally: '--set' would split them at
+		// ',', unescape '\', convert types and show them in errors. Only
+		// the name is parsed, with a placeholder replaced below.
+		placeholder := fmt.Sprintf("epinio-installer-value-%d", i)
+		literals[placeholder] = val.Value
+		if err := strvals.ParseIntoString(fmt.Sprintf("%s=%s", val.Name, placeholder), set); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse value name '%s'", val.Name)
+		}
+	}
+	return mergeValues(vals, replaceLiterals(set, literals).(map[string]interface{})), nil
+}
+
+// replaceLiterals replaces the placeholder strings in the parsed values
+// with their literal values
+func replaceLiterals(v interface{}, literals map[string]string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = replaceLiterals(item, literals)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = replaceLiterals(item, literals)
+		}
+	case string:
+		if literal, ok := literals[v]; ok {
+			return literal
+		}
+	}
+	return v
+}
+
+// mergeValues returns the deep merge of a and b, b wins. Neither a nor b
+// are modified.
+func mergeValues(a, b map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(a))
+	for k, v := range a {
+		out[k] = v
+	}
+	for k, v := range b {
+		if bm, ok := v.(map[string]interface{}); ok {
+			if am, ok := out[k].(map[string]interface{}); ok {
+				out[k] = mergeValues(am, bm)
+				continue
+			}
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// helmTimeout returns how long helm waits for the release's resources,
+// the component's timeout if it has one
+func helmTimeout(c Component) time.Duration {
+	if c.Timeout > 0 {
+		return time.Duration(c.Timeout)
+	}
+	return duration.ToDeployment()
+}
+
+// debugLog forwards the helm SDK's debug output to the logger
+func debugLog(log logr.Logger) action.DebugLog {
+	return func(format string, v ...interface{}) {
+		log.V(1).Info(fmt.Sprintf(format, v...))
+	}
+}
+
+// helmUpdateArgs returns the helm CLI arguments equivalent to Update, it
+// is used to describe the installation
+func helmUpdateArgs(c Component) ([]string, error) {
 	args := []string{"upgrade", c.Source.Name, "--install", "--namespace", c.Namespace, "--create-namespace", "--wait"}
+	if c.Timeout > 0 {
+		args = append(args, "--timeout", c.Timeout.String())
+	}
 
 	if c.Source.IsPath() {
 		args = append(args, c.Source.Path)
 	} else if c.Source.IsURL() {
 		args = append(args, c.Source.URL)
+	} else if c.Source.IsOCI() {
+		args = append(args, c.Source.Chart)
+		if c.Source.Digest != "" {
+			args[len(args)-1] += "@" + c.Source.Digest
+		}
+		if c.Source.Version != "" {
+			args = append(args, "--version", c.Source.Version)
+		}
+		if r := c.Source.Registry; r != nil {
+			if r.Config != "" {
+				args = append(args, "--registry-config", r.Config)
+			}
+			if r.PlainHTTP {
+				args = append(args, "--plain-http")
+			}
+			if r.Insecure {
+				args = append(args, "--insecure-skip-tls-verify")
+			}
+		}
 	} else if c.Source.IsHelmRef() {
 		args = append(args, "--repo", c.Source.URL)
 		if c.Source.Version != "" {
@@ -26,39 +462,28 @@ func helmUpdate(log logr.Logger, c Component) error {
 		}
 		args = append(args, c.Source.Chart)
 	} else {
-		return errors.New("helm source is incomplete")
+		return nil, errors.New("helm source is incomplete")
 	}
 
+	for _, path := range c.ValuesFiles {
+		args = append(args, "--values", path)
+	}
+	if len(c.ValuesObject) > 0 {
+		// the values object is read from stdin
+		args = append(args, "--values", "-")
+	}
 	for _, val := range c.Values {
 		args = append(args, "--set", fmt.Sprintf("%s=%s", val.Name, val.Value))
 	}
 
-	log.Info("run", "args", args)
-	if out, err := exec.RunProc(currentdir, false, "helm", args...); err != nil {
-		log.V(1).Info("helm result", "error", err, "out", out)
-		return errors.Wrap(err, fmt.Sprintf("failed installing %s, output:\n%s", c.ID, out))
-	}
-
-	log.V(1).Info("done")
-	return nil
+	return args, nil
 }
 
-func helmUninstall(log logr.Logger, c Component) error {
-	currentdir, _ := os.Getwd()
+// helmUninstallArgs returns the helm CLI arguments equivalent to Uninstall
+func helmUninstallArgs(c Component) []string {
 	args := []string{"uninstall", c.Source.Name, "--namespace", c.Namespace, "--wait"}
-
-	log.Info("run", "args", args)
-	if out, err := exec.RunProc(currentdir, false, "helm", args...); err != nil {
-		if strings.Contains(out, "not found") {
-			return nil
-		}
-
-		log.V(1).Info("helm result", "error", err, "out", out)
-		return errors.Wrap(err, fmt.Sprintf("failed uninstalling %s, output:\n%s", c.ID, out))
+	if c.Timeout > 0 {
+		args = append(args, "--timeout", c.Timeout.String())
 	}
-
-	// TODO we could delete the namespace if it was created by helm
-
-	log.V(1).Info("done")
-	return nil
+	return args
 }
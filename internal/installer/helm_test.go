@@ -0,0 +1,239 @@
+package installer_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/go-logr/logr"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+
+	"github.com/epinio/installer/internal/installer"
+)
+
+var _ = Describe("HelmClient", func() {
+	var cfg *action.Configuration
+	var helm *installer.HelmClient
+	var c installer.Component
+
+	BeforeEach(func() {
+		cfg = &action.Configuration{
+			Releases:     storage.Init(driver.NewMemory()),
+			KubeClient:   &kubefake.PrintingKubeClient{Out: ioutil.Discard},
+			Capabilities: chartutil.DefaultCapabilities,
+		}
+		helm = installer.NewHelmClientForConfig(cfg, nil)
+
+		c = installer.Component{
+			ID:        "hello",
+			Type:      installer.Helm,
+			Namespace: "hello",
+			Source:    installer.Source{Name: "hello", Path: assetPath("charts/hello")},
+			Values:    installer.Values{{Name: "greeting", Value: "hi"}},
+		}
+	})
+
+	It("installs a chart from a path with values", func() {
+		err := helm.Update(context.TODO(), logr.Discard(), c)
+		Expect(err).ToNot(HaveOccurred())
+
+		rel, err := cfg.Releases.Last("hello")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rel.Version).To(Equal(1))
+		Expect(rel.Namespace).To(Equal("hello"))
+		Expect(rel.Config).To(HaveKeyWithValue("greeting", "hi"))
+		Expect(rel.Manifest).To(ContainSubstring(`greeting: "hi"`))
+	})
+
+	It("merges values files, the values object and values in order", func() {
+		m, err := installer.Load(assetPath("values-manifest.yml"))
+		Expect(err).ToNot(HaveOccurred())
+
+		err = helm.Update(context.TODO(), logr.Discard(), m.Components[0])
+		Expect(err).ToNot(HaveOccurred())
+
+		rel, err := cfg.Releases.Last("hello")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rel.Config).To(HaveKeyWithValue("greeting", "from-values"))
+		Expect(rel.Config).To(HaveKeyWithValue("replicas", BeNumerically("==", 2)))
+		Expect(rel.Config).To(HaveKeyWithValue("enabled", true))
+		Expect(rel.Config).To(HaveKeyWithValue("hosts", []interface{}{"a.example.com", "b.example.com"}))
+		Expect(rel.Config).To(HaveKeyWithValue("labels", map[string]interface{}{
+			"app":               "hello",
+			"tier":              "object",
+			"linkerd.io/inject": "enabled",
+		}))
+	})
+
+	It("sets resolved values literally", func() {
+		c.Values = installer.Values{
+			{Name: "greeting", Value: `a,b=c\d[0]`, ValueFrom: &installer.ValueSource{Env: "GREETING"}},
+			{Name: "extra.port", Value: "123", ValueFrom: &installer.ValueSource{Env: "PORT"}},
+			{Name: "extra.enabled", Value: "true"},
+		}
+		Expect(helm.Update(context.TODO(), logr.Discard(), c)).To(Succeed())
+
+		rel, err := cfg.Releases.Last("hello")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rel.Config).To(HaveKeyWithValue("greeting", `a,b=c\d[0]`))
+		Expect(rel.Config).To(HaveKeyWithValue("extra", map[string]interface{}{"port": "123", "enabled": true}))
+	})
+
+	It("upgrades an existing release", func() {
+		Expect(helm.Update(context.TODO(), logr.Discard(), c)).To(Succeed())
+
+		c.Values = installer.Values{{Name: "greeting", Value: "hey"}}
+		Expect(helm.Update(context.TODO(), logr.Discard(), c)).To(Succeed())
+
+		rel, err := cfg.Releases.Last("hello")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rel.Version).To(Equal(2))
+		Expect(rel.Config).To(HaveKeyWithValue("greeting", "hey"))
+	})
+
+	It("fails for incomplete sources", func() {
+		c.Source = installer.Source{Name: "hello", Chart: "hello"}
+		err := helm.Update(context.TODO(), logr.Discard(), c)
+		Expect(err).To(MatchError(ContainSubstring("helm source is incomplete")))
+	})
+
+	It("uninstalls a release", func() {
+		Expect(helm.Update(context.TODO(), logr.Discard(), c)).To(Succeed())
+		Expect(helm.Uninstall(context.TODO(), logr.Discard(), c)).To(Succeed())
+
+		_, err := cfg.Releases.Last("hello")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("ignores missing releases on uninstall", func() {
+		Expect(helm.Uninstall(context.TODO(), logr.Discard(), c)).To(Succeed())
+	})
+
+	It("returns the release status", func() {
+		status, err := helm.ReleaseStatus(context.TODO(), logr.Discard(), c)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(status).To(BeEmpty())
+
+		Expect(helm.Update(context.TODO(), logr.Discard(), c)).To(Succeed())
+		status, err = helm.ReleaseStatus(context.TODO(), logr.Discard(), c)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(status).To(Equal(release.StatusDeployed))
+	})
+
+	It("diffs a missing release", func() {
+		changes, err := helm.Diff(context.TODO(), logr.Discard(), c)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes).To(Equal([]installer.Change{{
+			Type:     installer.Added,
+			Resource: "release 'hello'",
+			Details:  []string{"chart: <none> -> hello-0.1.0"},
+		}}))
+	})
+
+	It("diffs values and rendered resources of the deployed release", func() {
+		Expect(helm.Update(context.TODO(), logr.Discard(), c)).To(Succeed())
+
+		changes, err := helm.Diff(context.TODO(), logr.Discard(), c)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes).To(BeEmpty())
+
+		c.Values = installer.Values{{Name: "greeting", Value: "hey"}, {Name: "extra.enabled", Value: "true"}}
+		changes, err = helm.Diff(context.TODO(), logr.Discard(), c)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes).To(Equal([]installer.Change{
+			{
+				Type:     installer.Changed,
+				Resource: "release 'hello' values",
+				Details:  []string{`extra.enabled: <none> -> true`, `greeting: "hi" -> "hey"`},
+			},
+			{
+				Type:     installer.Changed,
+				Resource: "ConfigMap 'hello/hello'",
+				Details:  []string{`data.greeting: "hi" -> "hey"`},
+			},
+		}))
+	})
+
+	It("doesn't show values from sources", func() {
+		c.Values = installer.Values{{Name: "greeting", Value: "old-s3cret", ValueFrom: &installer.ValueSource{Env: "GREETING"}}}
+		Expect(helm.Update(context.TODO(), logr.Discard(), c)).To(Succeed())
+
+		c.Values[0].Value = "new-s3cret"
+		changes, err := helm.Diff(context.TODO(), logr.Discard(), c)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes).ToNot(BeEmpty())
+		Expect(changes[0]).To(Equal(installer.Change{
+			Type:     installer.Changed,
+			Resource: "release 'hello' values",
+			Details:  []string{"greeting: changed"},
+		}))
+	})
+
+	It("rolls back to a previous revision", func() {
+		revision, err := helm.Revision(logr.Discard(), c)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(revision).To(Equal(0))
+
+		Expect(helm.Update(context.TODO(), logr.Discard(), c)).To(Succeed())
+		revision, err = helm.Revision(logr.Discard(), c)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(revision).To(Equal(1))
+
+		c.Values = installer.Values{{Name: "greeting", Value: "broken"}}
+		Expect(helm.Update(context.TODO(), logr.Discard(), c)).To(Succeed())
+		Expect(helm.Rollback(logr.Discard(), c, revision)).To(Succeed())
+
+		rel, err := cfg.Releases.Last("hello")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rel.Version).To(Equal(3))
+		Expect(rel.Config).To(HaveKeyWithValue("greeting", "hi"))
+	})
+
+	It("uninstalls a fresh install on rollback", func() {
+		Expect(helm.Update(context.TODO(), logr.Discard(), c)).To(Succeed())
+		Expect(helm.Rollback(logr.Discard(), c, 0)).To(Succeed())
+
+		_, err := cfg.Releases.Last("hello")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("verifies the checksum of chart archives", func() {
+		dir, err := ioutil.TempDir("", "helm-test-")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		chrt, err := loader.Load(assetPath("charts/hello"))
+		Expect(err).ToNot(HaveOccurred())
+		archive, err := chartutil.Save(chrt, dir)
+		Expect(err).ToNot(HaveOccurred())
+		b, err := ioutil.ReadFile(archive)
+		Expect(err).ToNot(HaveOccurred())
+		sum := sha256.Sum256(b)
+
+		c.Source.Path = archive
+		c.Source.SHA256 = strings.Repeat("0", 64)
+		err = helm.Update(context.TODO(), logr.Discard(), c)
+		Expect(err).To(MatchError(ContainSubstring("sha256 of '" + archive + "' is " + hex.EncodeToString(sum[:]))))
+
+		c.Source.SHA256 = hex.EncodeToString(sum[:])
+		Expect(helm.Update(context.TODO(), logr.Discard(), c)).To(Succeed())
+	})
+
+	It("can't verify the checksum of chart directories", func() {
+		c.Source.SHA256 = strings.Repeat("0", 64)
+		err := helm.Update(context.TODO(), logr.Discard(), c)
+		Expect(err).To(MatchError(ContainSubstring("only for chart archives")))
+	})
+})
@@ -0,0 +1,290 @@
+package installer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+type HookPhase string
+
+const (
+	PreInstall    HookPhase = "preInstall"
+	PostInstall   HookPhase = "postInstall"
+	PreUninstall  HookPhase = "preUninstall"
+	PostUninstall HookPhase = "postUninstall"
+
+	// hookOutputLines is the number of output lines included in errors
+	hookOutputLines = 10
+)
+
+// hooks returns the component's hooks for the phase
+func (c Component) hooks(phase HookPhase) []Hook {
+	if c.Hooks == nil {
+		return nil
+	}
+	switch phase {
+	case PreInstall:
+		return c.Hooks.PreInstall
+	case PostInstall:
+		return c.Hooks.PostInstall
+	case PreUninstall:
+		return c.Hooks.PreUninstall
+	case PostUninstall:
+		return c.Hooks.PostUninstall
+	}
+	return nil
+}
+
+// HookRunner runs the hooks of components. The output of commands and
+// jobs is logged line by line.
+type HookRunner struct {
+	client  kubernetes.Interface
+	log     logr.Logger
+	timeout time.Duration
+	sources *Sources
+}
+
+// NewHookRunner returns a runner, timeout is the default for hooks. Jobs
+// are read from sources.
+func NewHookRunner(client kubernetes.Interface, log logr.Logger, timeout time.Duration, sources *Sources) *HookRunner {
+	return &HookRunner{
+		client:  client,
+		log:     log,
+		timeout: timeout,
+		sources: sources,
+	}
+}
+
+// Run runs the component's hooks for the phase in order, it stops at the
+// first failure
+func (h *HookRunner) Run(ctx context.Context, c Component, phase HookPhase) error {
+	for i, hook := range c.hooks(phase) {
+		log := h.log.WithValues("component", c.ID, "hook", fmt.Sprintf("%s[%d]", phase, i))
+
+		var err error
+		if len(hook.Command) > 0 {
+			err = h.command(ctx, log, c, phase, hook)
+		} else {
+			err = h.job(ctx, log, c, hook)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "%s hook %d of '%s' failed", phase, i, c.ID)
+		}
+	}
+	return nil
+}
+
+// command runs the hook's command, its output is logged and the tail of
+// it returned with the error
+func (h *HookRunner) command(ctx context.Context, log logr.Logger, c Component, phase HookPhase, hook Hook) error {
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout(hook, h.timeout))
+	defer cancel()
+
+	log.Info("run", "command", shellJoin(hook.Command))
+
+	out := &logWriter{log: log}
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	cmd.Env = append(os.Environ(), hookEnv(c, phase)...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	err := cmd.Run()
+	out.Flush()
+	if err != nil {
+		if tail := out.Tail(); tail != "" {
+			return fmt.Errorf("%v, output:\n%s", err, strings.TrimSuffix(indent(tail, "  "), "\n"))
+		}
+		return err
+	}
+	return nil
+}
+
+// hookEnv returns the environment describing the component, the manifest
+// variables are passed like they are read by VariablesFromEnv
+func hookEnv(c Component, phase HookPhase) []string {
+	env := []string{
+		"EPINIO_COMPONENT_ID=" + string(c.ID),
+		"EPINIO_COMPONENT_NAMESPACE=" + c.Namespace,
+		"EPINIO_COMPONENT_TYPE=" + string(c.Type),
+		"EPINIO_HOOK=" + string(phase),
+	}
+	for _, k := range sortedKeys(c.Vars) {
+		env = append(env, EnvVarPrefix+k+"="+c.Vars[k])
+	}
+	return env
+}
+
+// job creates the hook's job, replacing an existing one, and waits for it
+// to complete. The logs of its pods are logged.
+func (h *HookRunner) job(ctx context.Context, log logr.Logger, c Component, hook Hook) error {
+	job, err := loadJob(h.sources, c, hook.Job)
+	if err != nil {
+		return err
+	}
+
+	jobs := h.client.BatchV1().Jobs(job.Namespace)
+	policy := WaitPolicy{Timeout: hookTimeout(hook, h.timeout), Interval: time.Second}
+
+	// jobs are immutable, so a previous run is deleted
+	propagation := metav1.DeletePropagationBackground
+	err = jobs.Delete(ctx, job.Name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err == nil {
+		log.Info("delete previous job", "job", job.Name, "namespace", job.Namespace)
+		err = policy.Poll(ctx, func(ctx context.Context) (bool, error) {
+			_, err := jobs.Get(ctx, job.Name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		})
+	}
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "failed to delete previous job '%s'", job.Name)
+	}
+
+	log.Info("create job", "job", job.Name, "namespace", job.Namespace)
+	if _, err := jobs.Create(ctx, job, metav1.CreateOptions{}); err != nil {
+		return errors.Wrapf(err, "failed to create job '%s'", job.Name)
+	}
+
+	err = policy.Poll(ctx, func(ctx context.Context) (bool, error) {
+		j, err := jobs.Get(ctx, job.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return jobFinished(j)
+	})
+	h.logJob(ctx, log, job)
+	return err
+}
+
+// loadJob reads the single job from the file at path, its namespace
+// defaults to the component's
+func loadJob(sources *Sources, c Component, path string) (*batchv1.Job, error) {
+	objs, err := loadObjectsFrom(sources, c, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(objs) != 1 || objs[0].GetKind() != "Job" {
+		return nil, fmt.Errorf("'%s' must contain a single job", path)
+	}
+
+	job := &batchv1.Job{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(objs[0].Object, job); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse job from '%s'", path)
+	}
+	if job.Namespace == "" {
+		job.Namespace = c.Namespace
+	}
+	if job.Namespace == "" {
+		job.Namespace = metav1.NamespaceDefault
+	}
+	return job, nil
+}
+
+// jobFinished is true if the job completed, it returns an error if the
+// job failed
+func jobFinished(j *batchv1.Job) (bool, error) {
+	for _, cond := range j.Status.Conditions {
+		if cond.Status != v1.ConditionTrue {
+			continue
+		}
+		switch cond.Type {
+		case batchv1.JobComplete:
+			return true, nil
+		case batchv1.JobFailed:
+			return false, fmt.Errorf("job '%s' failed: %s", j.Name, cond.Message)
+		}
+	}
+	return false, nil
+}
+
+// logJob logs the output of the job's pods
+func (h *HookRunner) logJob(ctx context.Context, log logr.Logger, job *batchv1.Job) {
+	// the job's context might be expired already
+	ctx, cancel := context.WithTimeout(context.Background(), diagnosticsTimeout)
+	defer cancel()
+
+	pods, err := h.client.CoreV1().Pods(job.Namespace).List(ctx, metav1.ListOptions{LabelSelector: "job-name=" + job.Name})
+	if err != nil {
+		log.Error(err, "failed to list pods of job", "job", job.Name)
+		return
+	}
+
+	for _, pod := range pods.Items {
+		for _, c := range pod.Spec.Containers {
+			logs, err := h.client.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &v1.PodLogOptions{Container: c.Name}).DoRaw(ctx)
+			if err != nil {
+				log.Error(err, "failed to get logs", "pod", pod.Name, "container", c.Name)
+				continue
+			}
+			out := &logWriter{log: log.WithValues("pod", pod.Name, "container", c.Name)}
+			_, _ = out.Write(logs)
+			out.Flush()
+		}
+	}
+}
+
+// hookTimeout returns the hook's timeout, or the default
+func hookTimeout(hook Hook, timeout time.Duration) time.Duration {
+	if hook.Timeout > 0 {
+		return time.Duration(hook.Timeout)
+	}
+	return timeout
+}
+
+// logWriter logs each line written to it and keeps the last lines
+type logWriter struct {
+	log     logr.Logger
+	partial []byte
+	tail    []string
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.line(string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush logs the last line, if it didn't end with a newline
+func (w *logWriter) Flush() {
+	if len(w.partial) > 0 {
+		w.line(string(w.partial))
+		w.partial = nil
+	}
+}
+
+// Tail returns the last lines of the output
+func (w *logWriter) Tail() string {
+	return strings.Join(w.tail, "\n")
+}
+
+func (w *logWriter) line(line string) {
+	line = strings.TrimRight(line, "\r")
+	w.log.Info("output", "line", line)
+	w.tail = append(w.tail, line)
+	if len(w.tail) > hookOutputLines {
+		w.tail = w.tail[1:]
+	}
+}
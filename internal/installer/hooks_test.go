@@ -0,0 +1,134 @@
+package installer_test
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/go-logr/logr/funcr"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/epinio/installer/internal/installer"
+)
+
+var _ = Describe("HookRunner", func() {
+	var (
+		client *fake.Clientset
+		hooks  *installer.HookRunner
+		lines  []string
+		c      installer.Component
+	)
+
+	BeforeEach(func() {
+		lines = nil
+		log := funcr.New(func(prefix, args string) {
+			lines = append(lines, args)
+		}, funcr.Options{})
+
+		client = fake.NewSimpleClientset()
+		hooks = installer.NewHookRunner(client, log, time.Minute, nil)
+
+		c = installer.Component{
+			ID:        "epinio",
+			Type:      installer.YAML,
+			Namespace: "epinio",
+			Vars:      installer.Variables{"greeting": "hi"},
+			Hooks:     &installer.Hooks{},
+		}
+	})
+
+	// finishJobs sets the condition on jobs, when they are created
+	finishJobs := func(cond batchv1.JobConditionType) {
+		client.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+			job.Status.Conditions = []batchv1.JobCondition{{Type: cond, Status: v1.ConditionTrue, Message: "BackoffLimitExceeded"}}
+			return false, nil, nil
+		})
+	}
+
+	It("runs commands with the component in the environment", func() {
+		c.Hooks.PostInstall = []installer.Hook{{Command: []string{"sh", "-c", "echo $EPINIO_COMPONENT_ID $EPINIO_HOOK $EPINIO_VAR_greeting"}}}
+
+		Expect(hooks.Run(context.TODO(), c, installer.PostInstall)).To(Succeed())
+		Expect(lines).To(ContainElement(ContainSubstring(`"line"="epinio postInstall hi"`)))
+	})
+
+	It("only runs the hooks of the phase", func() {
+		c.Hooks.PreInstall = []installer.Hook{{Command: []string{"false"}}}
+
+		Expect(hooks.Run(context.TODO(), c, installer.PostInstall)).To(Succeed())
+		Expect(hooks.Run(context.TODO(), installer.Component{ID: "none"}, installer.PreInstall)).To(Succeed())
+	})
+
+	It("returns the tail of the output of failed commands", func() {
+		c.Hooks.PreUninstall = []installer.Hook{
+			{Command: []string{"true"}},
+			{Command: []string{"sh", "-c", "seq 1 20; exit 3"}},
+		}
+
+		err := hooks.Run(context.TODO(), c, installer.PreUninstall)
+		Expect(err).To(MatchError(ContainSubstring("preUninstall hook 1 of 'epinio' failed")))
+		Expect(err.Error()).To(ContainSubstring("exit status 3, output:\n"))
+		Expect(err.Error()).To(ContainSubstring("  11\n"))
+		Expect(err.Error()).To(HaveSuffix("  20"))
+		Expect(err.Error()).ToNot(ContainSubstring("  10\n"))
+	})
+
+	It("stops commands after the hook's timeout", func() {
+		c.Hooks.PreInstall = []installer.Hook{{Command: []string{"sleep", "10"}, Timeout: installer.Duration(100 * time.Millisecond)}}
+
+		start := time.Now()
+		Expect(hooks.Run(context.TODO(), c, installer.PreInstall)).ToNot(Succeed())
+		Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+	})
+
+	It("creates jobs and waits for them to complete", func() {
+		finishJobs(batchv1.JobComplete)
+		_, err := client.CoreV1().Pods("epinio").Create(context.TODO(), &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "hook-1", Namespace: "epinio", Labels: map[string]string{"job-name": "hook"}},
+			Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "hook"}}},
+		}, metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		c.Hooks.PostInstall = []installer.Hook{{Job: assetPath("hook-job.yaml")}}
+		Expect(hooks.Run(context.TODO(), c, installer.PostInstall)).To(Succeed())
+
+		job, err := client.BatchV1().Jobs("epinio").Get(context.TODO(), "hook", metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(job.Spec.Template.Spec.Containers[0].Command).To(Equal([]string{"echo", "hi"}))
+		Expect(strings.Join(lines, "\n")).To(ContainSubstring(`"pod"="hook-1" "container"="hook" "line"="fake logs"`))
+	})
+
+	It("replaces jobs of previous runs", func() {
+		finishJobs(batchv1.JobComplete)
+		c.Hooks.PostInstall = []installer.Hook{{Job: assetPath("hook-job.yaml")}}
+
+		Expect(hooks.Run(context.TODO(), c, installer.PostInstall)).To(Succeed())
+		Expect(hooks.Run(context.TODO(), c, installer.PostInstall)).To(Succeed())
+		Expect(lines).To(ContainElement(ContainSubstring(`"msg"="delete previous job"`)))
+	})
+
+	It("fails if the job fails", func() {
+		finishJobs(batchv1.JobFailed)
+		c.Hooks.PostInstall = []installer.Hook{{Job: assetPath("hook-job.yaml")}}
+
+		err := hooks.Run(context.TODO(), c, installer.PostInstall)
+		Expect(err).To(MatchError(ContainSubstring("postInstall hook 0 of 'epinio' failed: job 'hook' failed: BackoffLimitExceeded")))
+	})
+
+	It("fails for files without a single job", func() {
+		c.Values = installer.Values{{Name: "email", Value: "epinio@epinio.io"}}
+		c.Hooks.PostInstall = []installer.Hook{{Job: assetPath("cluster-issuer.yaml")}}
+
+		err := hooks.Run(context.TODO(), c, installer.PostInstall)
+		Expect(err).To(MatchError(ContainSubstring("must contain a single job")))
+	})
+})
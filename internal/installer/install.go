@@ -5,27 +5,72 @@ import (
 
 	"github.com/epinio/installer/internal/kubernetes"
 	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
 )
 
 type Install struct {
 	cluster *kubernetes.Cluster
 	log     logr.Logger
 	ca      *ComponentActions
+	helm    *HelmClient
+	yaml    *YAMLClient
+	hooks   *HookRunner
+
+	resolver *Resolver
+
+	// store records the objects applied by YAML components, if not nil
+	store *StateStore
+
+	// atomic rolls back all helm components on failure
+	atomic bool
 }
 
 var _ Action = &Install{}
 
-func NewInstall(cluster *kubernetes.Cluster, log logr.Logger, ca *ComponentActions) *Install {
+// NewInstall returns the install action, the component's files are read
+// from sources and values with a source are read by the resolver. The objects of YAML components are recorded in the store,
+// if it's not nil. If atomic is true, all helm components are rolled back
+// on failure, not just those with rollbackOnFailure.
+func NewInstall(cluster *kubernetes.Cluster, log logr.Logger, ca *ComponentActions, sources *Sources, resolver *Resolver, store *StateStore, atomic bool) *Install {
 	return &Install{
-		ca:      ca,
-		cluster: cluster,
-		log:     log,
+		ca:       ca,
+		cluster:  cluster,
+		log:      log,
+		helm:     NewHelmClient(cluster, sources),
+		yaml:     NewYAMLClient(cluster.Dynamic, cluster.Mapper, sources),
+		hooks:    NewHookRunner(cluster.Kubectl, log, ca.timeout, sources),
+		resolver: resolver,
+		store:    store,
+		atomic:   atomic,
 	}
 }
 
+// Apply resolves the component's values, before installing it within the
+// component's timeout. Resolved secrets are redacted from the returned error.
 func (i Install) Apply(ctx context.Context, c Component) error {
+	return i.run(ctx, c, func(ctx context.Context, c Component) error {
+		return i.apply(ctx, c, nil)
+	})
+}
+
+// run resolves the component's values and calls apply within the
+// component's timeout, it's shared by the install and upgrade actions
+func (i Install) run(ctx context.Context, c Component, apply func(context.Context, Component) error) error {
+	c, err := i.resolver.Resolve(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := componentContext(ctx, c)
+	defer cancel()
+	return i.resolver.redactor.RedactError(componentError(ctx, c, apply(ctx, c)))
+}
+
+// apply installs the component. The post upgrade checks run last, a
+// failure rolls back the release like a failed wait complete check.
+func (i Install) apply(ctx context.Context, c Component, postUpgrade []ComponentAction) error {
 	log := i.log.WithValues("component", c.ID, "type", c.Type)
-	log.Info("apply install")
+	log.Info("apply install", "timeout", c.Timeout.String())
 
 	for _, chk := range c.PreDeploy {
 		log.V(2).Info("pre deploy", "checkType", string(chk.Type))
@@ -34,19 +79,43 @@ func (i Install) Apply(ctx context.Context, c Component) error {
 		}
 	}
 
+	if err := i.hooks.Run(ctx, c, PreInstall); err != nil {
+		return err
+	}
+
+	// rollback restores the previous state of a helm release, if enabled
+	rollback := func(err error) error { return err }
+
 	switch c.Type {
 	case Helm:
 		{
-			if err := helmUpdate(log.V(1).WithName("helm"), c); err != nil {
-				return err
+			if i.atomic || c.RollbackOnFailure {
+				hlog := log.V(1).WithName("helm")
+				revision, err := i.helm.Revision(hlog, c)
+				if err != nil {
+					return err
+				}
+				rollback = func(err error) error {
+					return i.rollback(hlog, c, revision, err)
+				}
+			}
+
+			if err := i.helm.Update(ctx, log.V(1).WithName("helm"), c); err != nil {
+				return rollback(err)
 			}
 		}
 
 	case YAML:
 		{
-			if err := yamlApply(log.V(1).WithName("yaml"), c); err != nil {
+			refs, err := i.yaml.Apply(ctx, log.V(1).WithName("yaml"), c)
+			if err != nil {
 				return err
 			}
+			if i.store != nil {
+				if err := i.store.RecordObjects(ctx, c.ID, refs); err != nil {
+					return err
+				}
+			}
 		}
 
 	case Namespace:
@@ -61,9 +130,44 @@ func (i Install) Apply(ctx context.Context, c Component) error {
 		log.V(2).Info("wait complete", "checkType", string(chk.Type))
 
 		if err := i.ca.Run(ctx, c, chk); err != nil {
-			return err
+			return rollback(err)
+		}
+	}
+
+	if err := i.hooks.Run(ctx, c, PostInstall); err != nil {
+		return rollback(err)
+	}
+
+	for _, chk := range postUpgrade {
+		log.V(2).Info("post upgrade", "checkType", string(chk.Type))
+
+		if err := i.ca.Run(ctx, c, chk); err != nil {
+			return rollback(err)
 		}
 	}
 
 	return nil
 }
+
+// rollback rolls the release back to the revision, or uninstalls it if
+// it was a fresh install. Nothing is rolled back, if the release is still
+// at the revision, e.g. because the chart failed to load. The returned
+// error explains the rollback.
+func (i Install) rollback(log logr.Logger, c Component, revision int, err error) error {
+	current, rerr := i.helm.Revision(log, c)
+	if rerr != nil {
+		return errors.Wrapf(err, "rollback failed: %v", rerr)
+	}
+	if current == revision {
+		return err
+	}
+
+	i.log.Info("rollback after failure", "component", c.ID, "revision", revision, "error", err.Error())
+	if rerr := i.helm.Rollback(log, c, revision); rerr != nil {
+		return errors.Wrapf(err, "rollback failed: %v", rerr)
+	}
+	if revision == 0 {
+		return errors.Wrapf(err, "uninstalled release '%s'", c.Source.Name)
+	}
+	return errors.Wrapf(err, "rolled back release '%s' to revision %d", c.Source.Name, revision)
+}
@@ -0,0 +1,129 @@
+package installer_test
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/go-logr/logr"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+
+	"github.com/epinio/installer/internal/installer"
+)
+
+var _ = Describe("Install", func() {
+	var (
+		ctx    context.Context
+		cfg    *action.Configuration
+		helm   *installer.HelmClient
+		events bytes.Buffer
+		c      installer.Component
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		cfg = fakeHelm()
+		helm = installer.NewHelmClientForConfig(cfg, nil)
+		events.Reset()
+
+		c = installer.Component{
+			ID:           "hello",
+			Type:         installer.Helm,
+			Namespace:    "hello",
+			Source:       installer.Source{Name: "hello", Path: assetPath("charts/hello")},
+			WaitComplete: []installer.ComponentAction{certificateCheck("hello")},
+		}
+	})
+
+	// install returns the install action, the certificate checked by the
+	// component is ready, if ready is "True"
+	install := func(ready string, atomic bool) *installer.Install {
+		cluster := fakeCluster(certificate("hello", ready))
+		ca := installer.NewComponentActions(cluster, logr.Discard(), time.Second, nil, installer.NewEvents(&events, installer.NewRedactor()))
+		i := installer.NewInstall(cluster, logr.Discard(), ca, nil, installer.NewResolver(nil, installer.NewRedactor()), nil, atomic)
+		i.SetHelmClient(helm)
+		return i
+	}
+
+	// greeting returns the greeting value of the release's latest revision
+	greeting := func() (int, interface{}) {
+		rel, err := cfg.Releases.Last("hello")
+		Expect(err).ToNot(HaveOccurred())
+		return rel.Version, rel.Config["greeting"]
+	}
+
+	Context("when the previous revision exists", func() {
+		BeforeEach(func() {
+			Expect(install("True", false).Apply(ctx, c)).To(Succeed())
+			c.Values = installer.Values{{Name: "greeting", Value: "hi"}}
+		})
+
+		It("rolls back to it, if a wait complete check fails and rollbackOnFailure is set", func() {
+			c.RollbackOnFailure = true
+			err := install("False", false).Apply(ctx, c)
+			Expect(err).To(MatchError(ContainSubstring("rolled back release 'hello' to revision 1")))
+
+			version, value := greeting()
+			Expect(version).To(Equal(3))
+			Expect(value).To(BeNil())
+
+			rel, err := cfg.Releases.Get("hello", 2)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rel.Info.Status).To(Equal(release.StatusSuperseded))
+		})
+
+		It("rolls back to it, if a wait complete check fails and the install is atomic", func() {
+			err := install("False", true).Apply(ctx, c)
+			Expect(err).To(MatchError(ContainSubstring("rolled back release 'hello' to revision 1")))
+
+			version, value := greeting()
+			Expect(version).To(Equal(3))
+			Expect(value).To(BeNil())
+		})
+
+		It("keeps the failed revision, if rollback isn't enabled", func() {
+			err := install("False", false).Apply(ctx, c)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).ToNot(ContainSubstring("rolled back"))
+
+			version, value := greeting()
+			Expect(version).To(Equal(2))
+			Expect(value).To(Equal("hi"))
+		})
+
+		It("doesn't roll back, if the upgrade didn't create a revision", func() {
+			c.RollbackOnFailure = true
+			c.Source.Path = assetPath("charts/missing")
+			err := install("True", false).Apply(ctx, c)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).ToNot(ContainSubstring("rolled back"))
+
+			version, value := greeting()
+			Expect(version).To(Equal(1))
+			Expect(value).To(BeNil())
+		})
+	})
+
+	It("uninstalls a fresh install, if a wait complete check fails", func() {
+		c.RollbackOnFailure = true
+		err := install("False", false).Apply(ctx, c)
+		Expect(err).To(MatchError(ContainSubstring("uninstalled release 'hello'")))
+
+		_, err = cfg.Releases.Last("hello")
+		Expect(err).To(MatchError(driver.ErrReleaseNotFound))
+	})
+
+	It("doesn't uninstall, if the install failed before creating the release", func() {
+		c.RollbackOnFailure = true
+		c.Source.Path = assetPath("charts/missing")
+		err := install("True", false).Apply(ctx, c)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).ToNot(ContainSubstring("uninstalled"))
+		Expect(err.Error()).ToNot(ContainSubstring("rollback failed"))
+	})
+})
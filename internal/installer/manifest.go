@@ -1,8 +1,11 @@
 package installer
 
 import (
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -21,12 +24,23 @@ const (
 	Pod          ActionType = "pod"
 	Loadbalancer ActionType = "loadbalancer"
 	CRD          ActionType = "crd"
+	Deployment   ActionType = "deployment"
+	StatefulSet  ActionType = "statefulset"
+	DaemonSet    ActionType = "daemonset"
+	Secret       ActionType = "secret"
+	Condition    ActionType = "condition"
+	// NamespaceCheck waits for the namespace in the selector to exist
+	NamespaceCheck ActionType = "namespace"
 
 	Label      ValueType = "label"
 	Annotation ValueType = "annotation"
 )
 
 type Manifest struct {
+	// Variables are the defaults for templates in the components, they
+	// can be overridden on the command line
+	Variables Variables `yaml:"variables"`
+
 	// Components are known to Epinio, this describes how to install them
 	Components Components
 }
@@ -50,20 +64,87 @@ type Component struct {
 	// WaitComplete is a list of checks to make sure the component is complete
 	WaitComplete []ComponentAction `json:"wait_complete,omitempty" yaml:"waitComplete"`
 
+	// PreUpgrade checks make sure an installed component can be upgraded
+	PreUpgrade []ComponentAction `json:"pre_upgrade_check,omitempty" yaml:"preUpgrade"`
+
+	// PostUpgrade checks make sure an upgraded component works, they run
+	// after the wait complete checks and post install hooks. Like those,
+	// their failure rolls back the release, if rollback is enabled.
+	PostUpgrade []ComponentAction `json:"post_upgrade_check,omitempty" yaml:"postUpgrade"`
+
 	// Source for the component (was repo/path/..)
 	Source Source
 
-	// Values to be used when installing this component
+	// ValuesFiles are YAML files with helm values, later files override earlier ones
+	ValuesFiles []string `json:"values_files,omitempty" yaml:"valuesFiles"`
+
+	// ValuesObject holds nested, typed helm values, it overrides the values files
+	ValuesObject ValuesObject `json:"values_object,omitempty" yaml:"valuesObject"`
+
+	// Values to be used when installing this component, for helm they
+	// override the values files and the values object, like '--set'
 	Values Values
 
 	// Needs is used to build a DAG of components for the installation order
-	Needs DeploymentID
+	Needs DeploymentIDs
+
+	// Hooks run commands or jobs before and after the component is
+	// installed or uninstalled
+	Hooks *Hooks `json:"hooks,omitempty" yaml:"hooks"`
+
+	// RollbackOnFailure rolls a helm release back to its previous revision,
+	// or uninstalls it, if it or its wait complete checks fail
+	RollbackOnFailure bool `json:"rollback_on_failure,omitempty" yaml:"rollbackOnFailure"`
+
+	// Timeout limits the time to install or uninstall the component,
+	// including its checks
+	Timeout Duration `json:"timeout,omitempty" yaml:"timeout"`
+
+	// Vars are the manifest's variables, set by Expand. They are not part
+	// of the hash, fields using them are expanded in place.
+	Vars Variables `json:"-" yaml:"-"`
+
+	// contents are set by ReadContents
+	contents *contents
 }
 
 func (c Component) String() string {
 	return string(c.ID)
 }
 
+// DeploymentIDs is a list of component IDs, in YAML it can be given as a
+// single scalar or as a sequence
+type DeploymentIDs []DeploymentID
+
+// UnmarshalYAML accepts both `needs: linkerd` and `needs: [linkerd, traefik]`
+func (ids *DeploymentIDs) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var id DeploymentID
+	if err := unmarshal(&id); err == nil {
+		*ids = nil
+		if id != "" {
+			*ids = DeploymentIDs{id}
+		}
+		return nil
+	}
+
+	var list []DeploymentID
+	if err := unmarshal(&list); err != nil {
+		return err
+	}
+	*ids = list
+	return nil
+}
+
+// Contains returns true if id is in the list
+func (ids DeploymentIDs) Contains(id DeploymentID) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
 type Components []Component
 
 func (cs Components) IDs() []DeploymentID {
@@ -92,11 +173,147 @@ func (vals Values) ToMap() map[string]string {
 	return m
 }
 
+// ValuesObject is a nested map of helm values
+type ValuesObject map[string]interface{}
+
+// UnmarshalYAML converts nested maps to have string keys, as expected by
+// helm and needed to encode the values as JSON
+func (vo *ValuesObject) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var m map[string]interface{}
+	if err := unmarshal(&m); err != nil {
+		return err
+	}
+
+	for k, v := range m {
+		sv, err := stringKeys(v)
+		if err != nil {
+			return err
+		}
+		m[k] = sv
+	}
+	*vo = m
+	return nil
+}
+
+// stringKeys returns v with all nested maps converted to map[string]interface{}
+func stringKeys(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			ks, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("key '%v' in values object is not a string", k)
+			}
+			se, err := stringKeys(e)
+			if err != nil {
+				return nil, err
+			}
+			m[ks] = se
+		}
+		return m, nil
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			se, err := stringKeys(e)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = se
+		}
+		return l, nil
+	}
+	return v, nil
+}
+
+// Hooks act on the cluster, unlike checks which only wait. Post install
+// hooks run after the wait complete checks.
+type Hooks struct {
+	PreInstall    []Hook `json:"pre_install,omitempty" yaml:"preInstall"`
+	PostInstall   []Hook `json:"post_install,omitempty" yaml:"postInstall"`
+	PreUninstall  []Hook `json:"pre_uninstall,omitempty" yaml:"preUninstall"`
+	PostUninstall []Hook `json:"post_uninstall,omitempty" yaml:"postUninstall"`
+}
+
+// Hook runs either a local command, or the Job from a YAML file and
+// waits for it to complete
+type Hook struct {
+	// Command is run with the component's ID, namespace and type and the
+	// manifest's variables in its environment
+	Command []string `json:"command,omitempty" yaml:"command"`
+
+	// Job is the path to a YAML file with a single Job, it's always
+	// rendered as a template. An existing job of the same name is
+	// replaced.
+	Job string `json:"job,omitempty" yaml:"job"`
+
+	// Timeout defaults to the deployment timeout
+	Timeout Duration `json:"timeout,omitempty" yaml:"timeout"`
+}
+
 type ComponentAction struct {
-	// Type is 'pod', 'loadbalancer' or 'crd', the check is implemented in code
-	Type      ActionType `json:"type" yaml:"type"`
-	Selector  string     `json:"selector,omitempty" yaml:"selector"`
-	Namespace string     `json:"namespace" yaml:"namespace"`
+	// Type is e.g. 'pod', 'deployment' or 'crd', the check is implemented in code
+	Type ActionType `json:"type" yaml:"type"`
+
+	// Selector is a label selector for pods, otherwise the name of the object
+	Selector  string `json:"selector,omitempty" yaml:"selector"`
+	Namespace string `json:"namespace" yaml:"namespace"`
+
+	// APIVersion and Kind select the resource of a 'condition' check, its
+	// objects are given by Name or by a label Selector
+	APIVersion string `json:"apiVersion,omitempty" yaml:"apiVersion"`
+	Kind       string `json:"kind,omitempty" yaml:"kind"`
+	Name       string `json:"name,omitempty" yaml:"name"`
+
+	// Condition is the type of the status condition to wait for, e.g.
+	// 'Ready', its Status defaults to 'True'
+	Condition string `json:"condition,omitempty" yaml:"condition"`
+	Status    string `json:"status,omitempty" yaml:"status"`
+
+	// JSONPath is evaluated instead of a condition, e.g. '{.status.phase}',
+	// until its result equals Value
+	JSONPath string `json:"jsonPath,omitempty" yaml:"jsonPath"`
+	Value    string `json:"value,omitempty" yaml:"value"`
+
+	// Timeout and Interval override the check type's defaults, they are
+	// not affected by the timeout multiplier. Retries is the number of
+	// errors tolerated while polling, e.g. because the API is unavailable.
+	Timeout  Duration `json:"timeout,omitempty" yaml:"timeout"`
+	Interval Duration `json:"interval,omitempty" yaml:"interval"`
+	Retries  int      `json:"retries,omitempty" yaml:"retries"`
+}
+
+// Duration is written as a string in the manifest, e.g. '90s' or '5m'
+type Duration time.Duration
+
+// UnmarshalYAML parses the duration with time.ParseDuration
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration '%s'", s)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON parses the string written by MarshalJSON
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	return d.UnmarshalYAML(func(v interface{}) error {
+		return json.Unmarshal(b, v)
+	})
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
 }
 
 // Source describes the resource to be installed
@@ -105,15 +322,74 @@ type ComponentAction struct {
 // By `Path` to an unpacked chart directory: helm install mynginx ./nginx
 // By absolute `URL`: helm install mynginx https://example.com/charts/nginx-1.2.3.tgz
 // By `Chart` reference and repo `URL` and optionally `Version`: helm install --repo https://example.com/charts/ --version 0.1.2 mynginx nginx
+// By `Git` repository, ref and path to the chart directory
+// By OCI `Chart` reference and `Version` or `Digest`: helm install mynginx oci://example.com/charts/nginx --version 0.1.2
+// YAML:
+// By `Path`, by `Git` repository, ref and path, or by HTTP(S) `URL`: kubectl apply -f https://example.com/release.yaml
+// Files and chart archives are verified, if `SHA256` is set
 type Source struct {
 	// Name is the name of the helm release
 	Name string `yaml:"name"`
 
-	// Chart is the name of the helm chart, needs a URL for the repo
+	// Chart is the name of the helm chart, needs a URL for the repo,
+	// or an 'oci://' reference
 	Chart   string `yaml:"chart"`
 	Path    string `yaml:"path"`
 	URL     string `yaml:"url"`
 	Version string `yaml:"version"`
+
+	// Digest pins an OCI chart, e.g. 'sha256:...'
+	Digest string `json:",omitempty" yaml:"digest"`
+
+	// Registry configures the access to the registry of an OCI chart
+	Registry *Registry `json:",omitempty" yaml:"registry"`
+
+	// Git is a chart directory or YAML file in a git repository,
+	// instead of a path
+	Git *GitSource `json:",omitempty" yaml:"git"`
+
+	// SHA256 is the hex checksum of the YAML file or chart archive, it
+	// is verified before the source is used
+	SHA256 string `json:",omitempty" yaml:"sha256"`
+
+	// Template renders the YAML file with the component's values and the
+	// manifest's variables, files with values are always rendered
+	Template bool `json:",omitempty" yaml:"template"`
+}
+
+// GitSource is a chart directory or YAML file in a git repository
+type GitSource struct {
+	// URL of the repository, e.g. 'https://github.com/epinio/helm-charts'
+	// or 'file:///src/helm-charts'
+	URL string `json:",omitempty" yaml:"url"`
+
+	// Ref is a branch, tag or commit, defaults to the remote's HEAD
+	Ref string `json:",omitempty" yaml:"ref"`
+
+	// Path is the chart directory or YAML file, relative to the
+	// repository's root
+	Path string `json:",omitempty" yaml:"path"`
+}
+
+func (g GitSource) String() string {
+	ref := g.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	return fmt.Sprintf("%s@%s", g.URL, ref)
+}
+
+// Registry configures the access to an OCI registry
+type Registry struct {
+	// Config is the path of a docker config file with credentials, the
+	// default docker config is used as a fallback
+	Config string `json:",omitempty" yaml:"config"`
+
+	// PlainHTTP uses HTTP instead of HTTPS
+	PlainHTTP bool `json:",omitempty" yaml:"plainHTTP"`
+
+	// Insecure skips the verification of the registry's certificate
+	Insecure bool `json:",omitempty" yaml:"insecure"`
 }
 
 func (s Source) IsPath() bool {
@@ -125,13 +401,69 @@ func (s Source) IsURL() bool {
 }
 
 func (s Source) IsHelmRef() bool {
-	return s.Path == "" && s.Chart != "" && s.URL != ""
+	return s.Path == "" && s.Chart != "" && s.URL != "" && !strings.HasPrefix(s.Chart, OCIScheme)
+}
+
+// IsGit is true if the chart or YAML file is checked out from git
+func (s Source) IsGit() bool {
+	return s.Git != nil && s.Path == "" && s.Chart == "" && s.URL == ""
+}
+
+// location describes where the source is read from
+func (s Source) location() string {
+	if s.Git != nil {
+		return fmt.Sprintf("%s:%s", s.Git, s.Git.Path)
+	}
+	if s.Path == "" {
+		return s.URL
+	}
+	return s.Path
+}
+
+// IsOCI is true if the chart is pulled from an OCI registry
+func (s Source) IsOCI() bool {
+	return s.Path == "" && s.URL == "" && strings.HasPrefix(s.Chart, OCIScheme)
 }
 
 type Value struct {
 	Name  string
 	Value string
 	Type  ValueType
+
+	// ValueFrom is resolved when the component is applied, instead of using Value
+	ValueFrom *ValueSource `json:"valueFrom,omitempty" yaml:"valueFrom"`
+}
+
+// ValueSource reads a value from exactly one of its sources
+type ValueSource struct {
+	// SecretKeyRef selects a key of a secret in the cluster
+	SecretKeyRef *SecretKeyRef `json:"secretKeyRef,omitempty" yaml:"secretKeyRef"`
+
+	// Env is the name of an environment variable
+	Env string `json:"env,omitempty" yaml:"env"`
+
+	// File is the path of a local file, trailing newlines are removed
+	File string `json:"file,omitempty" yaml:"file"`
+}
+
+// SecretKeyRef selects a key of a secret, the namespace defaults to the component's
+type SecretKeyRef struct {
+	Name      string `json:"name" yaml:"name"`
+	Namespace string `json:"namespace,omitempty" yaml:"namespace"`
+	Key       string `json:"key" yaml:"key"`
+}
+
+// String describes the source, without revealing the value
+func (vs ValueSource) String() string {
+	switch {
+	case vs.SecretKeyRef != nil:
+		return fmt.Sprintf("secret %s/%s key %s", vs.SecretKeyRef.Namespace, vs.SecretKeyRef.Name, vs.SecretKeyRef.Key)
+	case vs.Env != "":
+		return fmt.Sprintf("env %s", vs.Env)
+	case vs.File != "":
+		return fmt.Sprintf("file %s", vs.File)
+	}
+	return "empty source"
 }
 
 func Load(path string) (*Manifest, error) {
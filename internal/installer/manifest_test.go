@@ -20,5 +20,14 @@ var _ = Describe("InstallManifest", func() {
 			Expect(traefik.WaitComplete).To(HaveLen(2))
 			Expect(traefik.WaitComplete[0].Type).To(Equal(installer.Pod))
 		})
+
+		It("loads a single need or a list of needs", func() {
+			m, err := installer.Load(assetPath("test-manifest.yml"))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(m.Components[0].Needs).To(BeEmpty())
+			Expect(m.Components[2].Needs).To(Equal(installer.DeploymentIDs{"linkerd"}))
+			Expect(m.Components[9].Needs).To(Equal(installer.DeploymentIDs{"tekton", "cert-manager"}))
+		})
 	})
 })
@@ -8,16 +8,7 @@ import (
 )
 
 func namespaceUpsert(ctx context.Context, cluster *kubernetes.Cluster, c Component) error {
-	labels := map[string]string{}
-	annotations := map[string]string{}
-	for _, val := range c.Values {
-		switch val.Type {
-		case Annotation:
-			annotations[val.Name] = val.Value
-		case Label:
-			labels[val.Name] = val.Value
-		}
-	}
+	labels, annotations := namespaceMeta(c)
 	if err := cluster.CreateNamespace(ctx, c.Namespace, labels, annotations); err != nil {
 		if apierrors.IsAlreadyExists(err) {
 			ns, err := cluster.GetNamespace(ctx, c.Namespace)
@@ -43,5 +34,19 @@ func namespaceUpsert(ctx context.Context, cluster *kubernetes.Cluster, c Compone
 		return err
 	}
 	return nil
+}
 
+// namespaceMeta returns the labels and annotations from the component's values
+func namespaceMeta(c Component) (map[string]string, map[string]string) {
+	labels := map[string]string{}
+	annotations := map[string]string{}
+	for _, val := range c.Values {
+		switch val.Type {
+		case Annotation:
+			annotations[val.Name] = val.Value
+		case Label:
+			labels[val.Name] = val.Value
+		}
+	}
+	return labels, annotations
 }
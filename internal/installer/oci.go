@@ -0,0 +1,114 @@
+package installer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/containerd/containerd/remotes"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/registry"
+	"oras.land/oras-go/pkg/auth"
+	dockerauth "oras.land/oras-go/pkg/auth/docker"
+	"oras.land/oras-go/pkg/content"
+	"oras.land/oras-go/pkg/oras"
+)
+
+// OCIScheme prefixes chart references in OCI registries
+const OCIScheme = "oci://"
+
+// ociRef returns the registry reference of the source's chart, pinned by
+// digest or tagged by version
+func ociRef(s Source) (string, error) {
+	ref := strings.TrimPrefix(s.Chart, OCIScheme)
+	switch {
+	case s.Digest != "":
+		return ref + "@" + s.Digest, nil
+	case s.Version != "":
+		// tags can't contain '+', helm replaces it when pushing
+		return ref + ":" + strings.ReplaceAll(s.Version, "+", "_"), nil
+	}
+	return "", fmt.Errorf("oci:// chart '%s' needs a version or a digest", s.Chart)
+}
+
+// pullOCIChart pulls the source's chart from its registry. If both
+// digest and version are set, the chart's version has to match.
+func pullOCIChart(ctx context.Context, s Source) (*chart.Chart, error) {
+	ref, err := ociRef(s)
+	if err != nil {
+		return nil, err
+	}
+
+	resolver, err := ociResolver(s.Registry)
+	if err != nil {
+		return nil, err
+	}
+
+	var layers []ocispec.Descriptor
+	store := content.NewMemory()
+	_, err = oras.Copy(ctx, resolver, ref, store, "",
+		// chart layers have no file name
+		oras.WithPullEmptyNameAllowed(),
+		oras.WithAllowedMediaType(ocispec.MediaTypeImageManifest, registry.ConfigMediaType, registry.ChartLayerMediaType),
+		oras.WithLayerDescriptors(func(l []ocispec.Descriptor) { layers = l }),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to pull chart '%s'", ref)
+	}
+
+	for _, l := range layers {
+		if l.MediaType != registry.ChartLayerMediaType {
+			continue
+		}
+		_, b, ok := store.Get(l)
+		if !ok {
+			break
+		}
+		ch, err := loader.LoadArchive(bytes.NewReader(b))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load chart '%s'", ref)
+		}
+		if s.Digest != "" && s.Version != "" && ch.Metadata.Version != s.Version {
+			return nil, fmt.Errorf("chart '%s' has version %s, expected %s", ref, ch.Metadata.Version, s.Version)
+		}
+		return ch, nil
+	}
+	return nil, fmt.Errorf("'%s' is not a helm chart", ref)
+}
+
+// ociResolver returns a resolver using the registry's credentials from
+// its docker config, or the default docker config
+func ociResolver(r *Registry) (remotes.Resolver, error) {
+	if r == nil {
+		r = &Registry{}
+	}
+
+	configs := []string{}
+	if r.Config != "" {
+		configs = append(configs, r.Config)
+	}
+	client, err := dockerauth.NewClientWithDockerFallback(configs...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read registry credentials")
+	}
+
+	httpClient := &http.Client{}
+	if r.Insecure {
+		httpClient.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+
+	opts := []auth.ResolverOption{auth.WithResolverClient(httpClient)}
+	if r.PlainHTTP {
+		opts = append(opts, auth.WithResolverPlainHTTP())
+	}
+	return client.ResolverWithOpts(opts...)
+}
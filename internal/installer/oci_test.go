@@ -0,0 +1,142 @@
+package installer_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/distribution/distribution/v3/configuration"
+	_ "github.com/distribution/distribution/v3/registry/auth/htpasswd"
+	"github.com/distribution/distribution/v3/registry/handlers"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/go-logr/logr"
+	"golang.org/x/crypto/bcrypt"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+
+	"github.com/epinio/installer/internal/installer"
+)
+
+var _ = Describe("OCI charts", func() {
+	var (
+		server *httptest.Server
+		dir    string
+		host   string
+		pushed *registry.PushResult
+		cfg    *action.Configuration
+		helm   *installer.HelmClient
+		c      installer.Component
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "oci-test-")
+		Expect(err).ToNot(HaveOccurred())
+
+		// a registry, which needs the credentials from the docker config
+		hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+		Expect(err).ToNot(HaveOccurred())
+		htpasswd := filepath.Join(dir, "htpasswd")
+		Expect(ioutil.WriteFile(htpasswd, []byte("epinio:"+string(hash)+"\n"), 0600)).To(Succeed())
+
+		config := &configuration.Configuration{}
+		config.Storage = configuration.Storage{"inmemory": configuration.Parameters{}}
+		config.Auth = configuration.Auth{"htpasswd": configuration.Parameters{"realm": "test", "path": htpasswd}}
+		config.Log.Level = "error"
+		server = httptest.NewServer(handlers.NewApp(context.Background(), config))
+		host = strings.TrimPrefix(server.URL, "http://")
+
+		client, err := registry.NewClient(registry.ClientOptCredentialsFile(filepath.Join(dir, "config.json")))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(client.Login(host, registry.LoginOptBasicAuth("epinio", "secret"))).To(Succeed())
+
+		chrt, err := loader.Load(assetPath("charts/hello"))
+		Expect(err).ToNot(HaveOccurred())
+		archive, err := chartutil.Save(chrt, dir)
+		Expect(err).ToNot(HaveOccurred())
+		data, err := ioutil.ReadFile(archive)
+		Expect(err).ToNot(HaveOccurred())
+		pushed, err = client.Push(data, fmt.Sprintf("%s/charts/hello:0.1.0", host))
+		Expect(err).ToNot(HaveOccurred())
+
+		cfg = &action.Configuration{
+			Releases:     storage.Init(driver.NewMemory()),
+			KubeClient:   &kubefake.PrintingKubeClient{Out: ioutil.Discard},
+			Capabilities: chartutil.DefaultCapabilities,
+		}
+		helm = installer.NewHelmClientForConfig(cfg, nil)
+
+		c = installer.Component{
+			ID:        "hello",
+			Type:      installer.Helm,
+			Namespace: "hello",
+			Source: installer.Source{
+				Name:     "hello",
+				Chart:    "oci://" + host + "/charts/hello",
+				Version:  "0.1.0",
+				Registry: &installer.Registry{Config: filepath.Join(dir, "config.json"), PlainHTTP: true},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("installs a chart by version", func() {
+		Expect(helm.Update(context.TODO(), logr.Discard(), c)).To(Succeed())
+
+		rel, err := cfg.Releases.Last("hello")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rel.Chart.Metadata.Name).To(Equal("hello"))
+		Expect(rel.Chart.Metadata.Version).To(Equal("0.1.0"))
+	})
+
+	It("installs a chart by digest", func() {
+		c.Source.Version = ""
+		c.Source.Digest = pushed.Manifest.Digest
+		Expect(helm.Update(context.TODO(), logr.Discard(), c)).To(Succeed())
+
+		_, err := cfg.Releases.Last("hello")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("fails if the pinned chart has another version", func() {
+		c.Source.Version = "0.2.0"
+		c.Source.Digest = pushed.Manifest.Digest
+		err := helm.Update(context.TODO(), logr.Discard(), c)
+		Expect(err).To(MatchError(ContainSubstring("has version 0.1.0, expected 0.2.0")))
+	})
+
+	It("fails without credentials", func() {
+		c.Source.Registry.Config = filepath.Join(dir, "missing.json")
+		err := helm.Update(context.TODO(), logr.Discard(), c)
+		Expect(err).To(MatchError(ContainSubstring("failed to pull chart")))
+	})
+
+	It("fails for missing versions", func() {
+		c.Source.Version = "9.9.9"
+		err := helm.Update(context.TODO(), logr.Discard(), c)
+		Expect(err).To(MatchError(ContainSubstring("failed to pull chart '" + host + "/charts/hello:9.9.9'")))
+	})
+
+	It("prints the equivalent helm command", func() {
+		out := &strings.Builder{}
+		m := &installer.Manifest{Components: installer.Components{c}}
+		Expect(installer.NewDryRun(out, 0, 0, false, nil).Walk(context.TODO(), m.Components)).To(Succeed())
+		Expect(out.String()).To(ContainSubstring(fmt.Sprintf("oci://%s/charts/hello --version 0.1.0 --registry-config %s --plain-http", host, filepath.Join(dir, "config.json"))))
+	})
+})
@@ -10,18 +10,21 @@ func BuildPlan(components Components) (Components, error) {
 	// S ← Set of all nodes with no incoming edge
 	noedge := map[DeploymentID]bool{}
 
-	// graph has all the edges
-	graph := map[DeploymentID]DeploymentID{}
+	// graph has all the edges, from a node to all the nodes it needs
+	graph := map[DeploymentID]map[DeploymentID]bool{}
 	for _, c := range components {
-		if c.Needs == "" {
+		if len(c.Needs) == 0 {
 			continue
 		}
 
-		graph[c.ID] = c.Needs
+		graph[c.ID] = map[DeploymentID]bool{}
+		for _, n := range c.Needs {
+			graph[c.ID][n] = true
+		}
 	}
 
 	for _, c := range components {
-		if graph[c.ID] == "" {
+		if len(graph[c.ID]) == 0 {
 			noedge[c.ID] = true
 		}
 	}
@@ -42,13 +45,18 @@ func BuildPlan(components Components) (Components, error) {
 		plan = append(plan, n)
 
 		//     for each node m with an edge e from n to m do
-		for m, t := range graph {
+		for m, needs := range graph {
+			if !needs[n.ID] {
+				continue
+			}
+
 			//         remove edge e from the graph
+			delete(needs, n.ID)
+
 			//         if m has no other incoming edges then
 			//             insert m into S
-			if t == n.ID {
+			if len(needs) == 0 {
 				delete(graph, m)
-				// TODO other edges if needs is an array
 				noedge[m] = true
 			}
 		}
@@ -65,3 +73,70 @@ func BuildPlan(components Components) (Components, error) {
 
 	return plan, nil
 }
+
+// Waves groups the components into the batches Walk would start in
+// parallel, if every action took the same time. A component is in the
+// wave after the last wave containing one of its needs. Parallel limits
+// the size of the waves like Walker.Parallel, 0 means no limit.
+func Waves(components Components, parallel int) ([]Components, error) {
+	if _, err := BuildPlan(components); err != nil {
+		return nil, err
+	}
+	return waves(components, needs(components), parallel), nil
+}
+
+// ReverseWaves groups the components into the batches ReverseWalk would
+// start in parallel. A component is in the wave after the last wave
+// containing one of its needers.
+func ReverseWaves(components Components, parallel int) ([]Components, error) {
+	if _, err := BuildPlan(components); err != nil {
+		return nil, err
+	}
+	return waves(components, needers(components), parallel), nil
+}
+
+// waves starts the components the way Walker.run does, once all their
+// deps are done, but finishes all started components at the same time
+func waves(components Components, deps map[DeploymentID][]DeploymentID, parallel int) []Components {
+	index := map[DeploymentID]int{}
+	for i, c := range components {
+		index[c.ID] = i
+	}
+
+	waiting := map[DeploymentID]int{}
+	dependents := map[DeploymentID][]DeploymentID{}
+	ready := []int{}
+	for i, c := range components {
+		waiting[c.ID] = len(deps[c.ID])
+		for _, d := range deps[c.ID] {
+			dependents[d] = append(dependents[d], c.ID)
+		}
+		if waiting[c.ID] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	result := []Components{}
+	for len(ready) > 0 {
+		n := len(ready)
+		if parallel > 0 && n > parallel {
+			n = parallel
+		}
+
+		wave := Components{}
+		next := append([]int{}, ready[n:]...)
+		for _, i := range ready[:n] {
+			c := components[i]
+			wave = append(wave, c)
+			for _, d := range dependents[c.ID] {
+				waiting[d]--
+				if waiting[d] == 0 {
+					next = insertSorted(next, index[d])
+				}
+			}
+		}
+		result = append(result, wave)
+		ready = next
+	}
+	return result
+}
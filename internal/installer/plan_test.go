@@ -20,4 +20,63 @@ var _ = Describe("Plan", func() {
 		// Plan doesn't know about concurrency, though
 		Expect(plan.IDs()).To(Equal([]installer.DeploymentID{"epinio-namespace", "linkerd", "traefik", "cert-manager", "cluster-issuers", "cluster-certificates", "tekton", "tekton-pipelines", "kubed", "epinio"}))
 	})
+
+	It("waits for all 'needs' of a component", func() {
+		cs := installer.Components{
+			{ID: "c", Needs: installer.DeploymentIDs{"a", "b"}},
+			{ID: "a"},
+			{ID: "b", Needs: installer.DeploymentIDs{"a"}},
+		}
+
+		plan, err := installer.BuildPlan(cs)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.IDs()).To(Equal([]installer.DeploymentID{"a", "b", "c"}))
+	})
+
+	It("finds cycles", func() {
+		cs := installer.Components{
+			{ID: "a"},
+			{ID: "b", Needs: installer.DeploymentIDs{"a", "c"}},
+			{ID: "c", Needs: installer.DeploymentIDs{"b"}},
+		}
+
+		_, err := installer.BuildPlan(cs)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("groups components into waves", func() {
+		m, err := installer.Load(assetPath("test-manifest.yml"))
+		Expect(err).ToNot(HaveOccurred())
+
+		waves, err := installer.Waves(m.Components, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(waves).To(HaveLen(5))
+		Expect(waves[0].IDs()).To(Equal([]installer.DeploymentID{"epinio-namespace", "linkerd"}))
+		Expect(waves[2].IDs()).To(Equal([]installer.DeploymentID{"cert-manager", "kubed"}))
+		Expect(waves[4].IDs()).To(Equal([]installer.DeploymentID{"cluster-certificates", "tekton-pipelines", "epinio"}))
+
+		waves, err = installer.ReverseWaves(m.Components, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(waves).To(HaveLen(5))
+		Expect(waves[0].IDs()).To(Equal([]installer.DeploymentID{"epinio-namespace", "cluster-certificates", "tekton-pipelines", "kubed", "epinio"}))
+		Expect(waves[4].IDs()).To(Equal([]installer.DeploymentID{"linkerd"}))
+	})
+
+	It("limits the size of the waves like the walker", func() {
+		m, err := installer.Load(assetPath("test-manifest.yml"))
+		Expect(err).ToNot(HaveOccurred())
+
+		waves, err := installer.Waves(m.Components, 2)
+		Expect(err).ToNot(HaveOccurred())
+		for _, wave := range waves {
+			Expect(len(wave)).To(BeNumerically("<=", 2))
+		}
+		Expect(waves[0].IDs()).To(Equal([]installer.DeploymentID{"epinio-namespace", "linkerd"}))
+
+		waves, err = installer.ReverseWaves(m.Components, 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(waves).To(HaveLen(len(m.Components)))
+		Expect(waves[0].IDs()).To(Equal([]installer.DeploymentID{"epinio-namespace"}))
+		Expect(waves[len(waves)-1].IDs()).To(Equal([]installer.DeploymentID{"linkerd"}))
+	})
 })
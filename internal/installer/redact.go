@@ -0,0 +1,159 @@
+package installer
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+)
+
+// Redacted replaces secrets in logs and errors
+const Redacted = "[REDACTED]"
+
+// Redactor removes secrets, which were resolved at apply time, from log
+// lines and errors
+type Redactor struct {
+	lock     *sync.RWMutex
+	secrets  map[string]bool
+	replacer *strings.Replacer
+}
+
+// NewRedactor returns a redactor without any secrets
+func NewRedactor() *Redactor {
+	return &Redactor{
+		lock:     &sync.RWMutex{},
+		secrets:  map[string]bool{},
+		replacer: strings.NewReplacer(),
+	}
+}
+
+// Add registers a secret, empty strings are ignored
+func (r *Redactor) Add(secret string) {
+	if secret == "" {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.secrets[secret] {
+		return
+	}
+	r.secrets[secret] = true
+
+	// the longest secrets are replaced first, so no part of a secret
+	// containing another one is left
+	secrets := make([]string, 0, len(r.secrets))
+	for s := range r.secrets {
+		secrets = append(secrets, s)
+	}
+	sort.Slice(secrets, func(i, j int) bool {
+		if len(secrets[i]) != len(secrets[j]) {
+			return len(secrets[i]) > len(secrets[j])
+		}
+		return secrets[i] < secrets[j]
+	})
+	pairs := make([]string, 0, 2*len(secrets))
+	for _, s := range secrets {
+		pairs = append(pairs, s, Redacted)
+	}
+	r.replacer = strings.NewReplacer(pairs...)
+}
+
+// Redact replaces all secrets in s, a nil redactor returns s unchanged
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.replacer.Replace(s)
+}
+
+// RedactError returns err, or an error with its message without secrets
+// if it contains any. The redacted error still wraps err.
+func (r *Redactor) RedactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	if redacted := r.Redact(msg); redacted != msg {
+		return &redactedError{msg: redacted, err: err}
+	}
+	return err
+}
+
+// redactedError replaces the message of the wrapped error
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// Logger wraps the logger, so messages, string values and errors are redacted
+func (r *Redactor) Logger(log logr.Logger) logr.Logger {
+	return logr.New(&redactingSink{sink: log.GetSink(), redactor: r})
+}
+
+// redactingSink redacts everything before passing it to the wrapped sink
+type redactingSink struct {
+	sink     logr.LogSink
+	redactor *Redactor
+}
+
+var _ logr.CallDepthLogSink = &redactingSink{}
+
+// Init accounts for the additional stack frame of the wrapper
+func (s *redactingSink) Init(info logr.RuntimeInfo) {
+	info.CallDepth++
+	s.sink.Init(info)
+}
+
+func (s *redactingSink) Enabled(level int) bool {
+	return s.sink.Enabled(level)
+}
+
+func (s *redactingSink) Info(level int, msg string, keysAndValues ...interface{}) {
+	s.sink.Info(level, s.redactor.Redact(msg), s.redactValues(keysAndValues)...)
+}
+
+func (s *redactingSink) Error(err error, msg string, keysAndValues ...interface{}) {
+	s.sink.Error(s.redactor.RedactError(err), s.redactor.Redact(msg), s.redactValues(keysAndValues)...)
+}
+
+func (s *redactingSink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	return &redactingSink{sink: s.sink.WithValues(s.redactValues(keysAndValues)...), redactor: s.redactor}
+}
+
+func (s *redactingSink) WithName(name string) logr.LogSink {
+	return &redactingSink{sink: s.sink.WithName(name), redactor: s.redactor}
+}
+
+func (s *redactingSink) WithCallDepth(depth int) logr.LogSink {
+	if cd, ok := s.sink.(logr.CallDepthLogSink); ok {
+		return &redactingSink{sink: cd.WithCallDepth(depth), redactor: s.redactor}
+	}
+	return s
+}
+
+// redactValues returns a copy of the key/value pairs with strings and errors redacted
+func (s *redactingSink) redactValues(keysAndValues []interface{}) []interface{} {
+	out := make([]interface{}, len(keysAndValues))
+	for i, v := range keysAndValues {
+		switch v := v.(type) {
+		case string:
+			out[i] = s.redactor.Redact(v)
+		case error:
+			out[i] = s.redactor.RedactError(v)
+		default:
+			out[i] = v
+		}
+	}
+	return out
+}
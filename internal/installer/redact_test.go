@@ -0,0 +1,63 @@
+package installer_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/go-logr/logr/funcr"
+
+	"github.com/epinio/installer/internal/installer"
+)
+
+var _ = Describe("Redactor", func() {
+	It("redacts secrets from log lines", func() {
+		lines := []string{}
+		log := funcr.New(func(prefix, args string) {
+			lines = append(lines, prefix+" "+args)
+		}, funcr.Options{})
+
+		redactor := installer.NewRedactor()
+		redactor.Add("s3cret")
+		redactor.Add("")
+
+		log = redactor.Logger(log).WithName("test").WithValues("password", "s3cret")
+		log.Info("using s3cret", "token", "is s3cret", "count", 1)
+		log.Error(errors.New("bad s3cret"), "failed")
+
+		Expect(lines).To(HaveLen(2))
+		for _, l := range lines {
+			Expect(l).ToNot(ContainSubstring("s3cret"))
+		}
+		Expect(lines[0]).To(ContainSubstring(`"msg"="using [REDACTED]"`))
+		Expect(lines[0]).To(ContainSubstring(`"token"="is [REDACTED]"`))
+		Expect(lines[0]).To(ContainSubstring(`"count"=1`))
+		Expect(lines[1]).To(ContainSubstring(`"error"="bad [REDACTED]"`))
+	})
+
+	It("redacts secrets containing other secrets completely", func() {
+		for i := 0; i < 10; i++ {
+			redactor := installer.NewRedactor()
+			redactor.Add("pass")
+			redactor.Add("password123")
+			redactor.Add("word")
+			Expect(redactor.Redact("is password123 or pass")).To(Equal("is [REDACTED] or [REDACTED]"))
+		}
+	})
+
+	It("keeps the wrapped errors of redacted errors", func() {
+		redactor := installer.NewRedactor()
+		redactor.Add("s3cret")
+		err := redactor.RedactError(fmt.Errorf("bad s3cret: %w", context.Canceled))
+		Expect(err).To(MatchError("bad [REDACTED]: context canceled"))
+		Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+	})
+
+	It("keeps errors without secrets", func() {
+		err := errors.New("plain")
+		Expect(installer.NewRedactor().RedactError(err)).To(BeIdenticalTo(err))
+	})
+})
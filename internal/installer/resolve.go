@@ -0,0 +1,127 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+)
+
+// SecretGetter reads secrets from the cluster, it is implemented by kubernetes.Cluster
+type SecretGetter interface {
+	GetSecret(ctx context.Context, namespace, name string) (*v1.Secret, error)
+}
+
+// Resolver reads the values, which have a source, when a component is applied
+type Resolver struct {
+	secrets  SecretGetter
+	redactor *Redactor
+}
+
+// NewResolver returns a resolver, which registers all resolved values
+// with the redactor, so they don't show up in logs
+func NewResolver(secrets SecretGetter, redactor *Redactor) *Resolver {
+	return &Resolver{
+		secrets:  secrets,
+		redactor: redactor,
+	}
+}
+
+// Resolve returns a copy of the component, with the values of all sources filled in
+func (r *Resolver) Resolve(ctx context.Context, c Component) (Component, error) {
+	if !c.Values.hasSources() {
+		return c, nil
+	}
+
+	vals := make(Values, len(c.Values))
+	for i, val := range c.Values {
+		vals[i] = val
+		if val.ValueFrom == nil {
+			continue
+		}
+
+		s, err := r.value(ctx, sourceFor(c, *val.ValueFrom))
+		if err != nil {
+			return c, errors.Wrapf(err, "failed to resolve value '%s' of '%s'", val.Name, c.ID)
+		}
+		r.redactor.Add(s)
+		vals[i].Value = s
+	}
+
+	c.Values = vals
+	return c, nil
+}
+
+func (r *Resolver) value(ctx context.Context, vs ValueSource) (string, error) {
+	switch {
+	case vs.SecretKeyRef != nil:
+		ref := vs.SecretKeyRef
+		secret, err := r.secrets.GetSecret(ctx, ref.Namespace, ref.Name)
+		if err != nil {
+			return "", err
+		}
+		b, ok := secret.Data[ref.Key]
+		if !ok {
+			return "", fmt.Errorf("secret '%s/%s' has no key '%s'", ref.Namespace, ref.Name, ref.Key)
+		}
+		return string(b), nil
+
+	case vs.Env != "":
+		s, ok := os.LookupEnv(vs.Env)
+		if !ok {
+			return "", fmt.Errorf("environment variable '%s' is not set", vs.Env)
+		}
+		return s, nil
+
+	case vs.File != "":
+		b, err := os.ReadFile(vs.File)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+
+	return "", errors.New("value source is empty")
+}
+
+// sourceFor returns a copy of the source, with the secret's namespace
+// defaulting to the component's
+func sourceFor(c Component, vs ValueSource) ValueSource {
+	if vs.SecretKeyRef != nil && vs.SecretKeyRef.Namespace == "" {
+		ref := *vs.SecretKeyRef
+		ref.Namespace = c.Namespace
+		vs.SecretKeyRef = &ref
+	}
+	return vs
+}
+
+// hasSources is true if any value is read from a source
+func (vals Values) hasSources() bool {
+	for _, v := range vals {
+		if v.ValueFrom != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// placeholders returns a copy of the component, with the values of all
+// sources replaced by a description, e.g. for a dry run
+func placeholders(c Component) Component {
+	if !c.Values.hasSources() {
+		return c
+	}
+
+	vals := make(Values, len(c.Values))
+	for i, val := range c.Values {
+		vals[i] = val
+		if val.ValueFrom != nil {
+			vals[i].Value = fmt.Sprintf("<%s>", sourceFor(c, *val.ValueFrom))
+		}
+	}
+	c.Values = vals
+	return c
+}
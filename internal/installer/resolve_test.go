@@ -0,0 +1,99 @@
+package installer_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/epinio/installer/internal/installer"
+)
+
+// secrets is a fake secret getter
+type secrets map[string]*v1.Secret
+
+func (s secrets) GetSecret(ctx context.Context, namespace, name string) (*v1.Secret, error) {
+	if secret, ok := s[namespace+"/"+name]; ok {
+		return secret, nil
+	}
+	return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "secrets"}, name)
+}
+
+var _ = Describe("Resolver", func() {
+	var redactor *installer.Redactor
+	var resolver *installer.Resolver
+	var c installer.Component
+
+	BeforeEach(func() {
+		redactor = installer.NewRedactor()
+		resolver = installer.NewResolver(secrets{
+			"cert-manager/issuer": &v1.Secret{Data: map[string][]byte{"email": []byte("admin@example.com")}},
+		}, redactor)
+
+		c = installer.Component{
+			ID:        "cluster-issuers",
+			Namespace: "cert-manager",
+			Values: installer.Values{
+				{Name: "plain", Value: "plain"},
+				{Name: "email", ValueFrom: &installer.ValueSource{SecretKeyRef: &installer.SecretKeyRef{Name: "issuer", Key: "email"}}},
+			},
+		}
+	})
+
+	It("resolves values from a secret in the component's namespace", func() {
+		resolved, err := resolver.Resolve(context.TODO(), c)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resolved.Values.ToMap()).To(Equal(map[string]string{"plain": "plain", "email": "admin@example.com"}))
+
+		// the original is not modified
+		Expect(c.Values[1].Value).To(BeEmpty())
+		Expect(redactor.Redact("email is admin@example.com")).To(Equal("email is [REDACTED]"))
+	})
+
+	It("resolves values from the environment and from files", func() {
+		os.Setenv("EPINIO_TEST_PASSWORD", "s3cret")
+		defer os.Unsetenv("EPINIO_TEST_PASSWORD")
+
+		dir, err := ioutil.TempDir("", "resolve")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "token")
+		Expect(ioutil.WriteFile(path, []byte("t0ken\n"), 0600)).To(Succeed())
+
+		c.Values = installer.Values{
+			{Name: "password", ValueFrom: &installer.ValueSource{Env: "EPINIO_TEST_PASSWORD"}},
+			{Name: "token", ValueFrom: &installer.ValueSource{File: path}},
+		}
+		resolved, err := resolver.Resolve(context.TODO(), c)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resolved.Values.ToMap()).To(Equal(map[string]string{"password": "s3cret", "token": "t0ken"}))
+	})
+
+	It("fails for missing keys and variables", func() {
+		c.Values[1].ValueFrom.SecretKeyRef.Key = "missing"
+		_, err := resolver.Resolve(context.TODO(), c)
+		Expect(err).To(MatchError("failed to resolve value 'email' of 'cluster-issuers': secret 'cert-manager/issuer' has no key 'missing'"))
+
+		c.Values[1].ValueFrom = &installer.ValueSource{Env: "EPINIO_TEST_MISSING"}
+		_, err = resolver.Resolve(context.TODO(), c)
+		Expect(err).To(MatchError(ContainSubstring("environment variable 'EPINIO_TEST_MISSING' is not set")))
+	})
+
+	It("describes sources in a dry run", func() {
+		out := &bytes.Buffer{}
+		c.Type = installer.Helm
+		c.Source = installer.Source{Name: "issuer", Path: "chart"}
+		err := installer.NewDryRun(out, time.Minute, 0, false, nil).Apply(context.TODO(), c)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(out.String()).To(ContainSubstring(`--set "email=<secret cert-manager/issuer key email>"`))
+	})
+})
@@ -3,165 +3,224 @@ package installer
 import (
 	"context"
 	"fmt"
-	"os"
-	"sync"
-
-	"golang.org/x/sync/errgroup"
+	"sort"
+	"strings"
+	"time"
 )
 
 type Action interface {
 	Apply(context.Context, Component) error
 }
 
-// Walk all the nodes, apply Action and wait for it to finish. Walk nodes in parallel, if parents ("needs") are done.
-func Walk(ctx context.Context, plan Components, action Action) error {
-	// access to these vars from the goroutines will need to be
-	// synchronized by RWMutex to avoid races
-	done := map[DeploymentID]bool{}
-	running := map[DeploymentID]bool{}
-	for _, c := range plan {
-		done[c.ID] = false
-		running[c.ID] = false
+// ComponentError is the error of a single component's action
+type ComponentError struct {
+	ID  DeploymentID
+	Err error
+}
+
+// WalkError lists all components which failed, or were skipped because
+// of the failures
+type WalkError struct {
+	Failed  []ComponentError
+	Skipped []DeploymentID
+}
+
+func (e *WalkError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d component(s) failed", len(e.Failed))
+	if len(e.Skipped) > 0 {
+		fmt.Fprintf(&b, ", %d skipped", len(e.Skipped))
 	}
-	noErr := true
-
-	g := new(errgroup.Group)
-	lock := &sync.RWMutex{}
-	processMore := func() bool {
-		lock.RLock()
-		defer lock.RUnlock()
-		return noErr
+	for _, f := range e.Failed {
+		fmt.Fprintf(&b, "\n  failed '%s': %v", f.ID, f.Err)
 	}
-	for !allDone(lock, done) && processMore() {
-		for _, c := range plan {
-			// avoid pointer capture
-			c := c
-			lock.RLock()
-			if done[c.ID] {
-				//fmt.Printf("skip done: %s\n", c.ID)
-				lock.RUnlock()
-				continue
-			}
-			if running[c.ID] {
-				//fmt.Printf("skip running: %s\n", c.ID)
-				lock.RUnlock()
-				continue
-			}
-			if c.Needs != "" && !done[c.Needs] {
-				//fmt.Printf("skip '%s' for deps: %s (r:%v, d:%v)\n", c.ID, c.Needs, running[c.Needs], done[c.Needs])
-				lock.RUnlock()
-				continue
-			}
-			lock.RUnlock()
-
-			lock.Lock()
-			running[c.ID] = true
-			lock.Unlock()
-
-			g.Go(func() error {
-				err := action.Apply(ctx, c)
-				if err != nil {
-					fmt.Printf("error for '%s': %v\n", c.ID, err)
-					// don't start any more tasks, break the for loop
-					lock.Lock()
-					noErr = false
-					lock.Unlock()
-					return err
-				}
-
-				lock.Lock()
-				done[c.ID] = true
-				lock.Unlock()
-
-				return nil
-			})
+	if len(e.Skipped) > 0 {
+		ids := make([]string, 0, len(e.Skipped))
+		for _, id := range e.Skipped {
+			ids = append(ids, string(id))
 		}
+		fmt.Fprintf(&b, "\n  skipped: %s", strings.Join(ids, ", "))
 	}
+	return b.String()
+}
 
-	if err := g.Wait(); err != nil {
-		fmt.Println("failed to install all components")
-		return err
-	}
+// Walker applies an action to all components of a plan. Actions run in
+// parallel, as soon as the components they depend on are done.
+type Walker struct {
+	// Parallel limits the number of actions running at the same time, 0 means no limit
+	Parallel int
 
-	return nil
+	// Events receives the progress of the components, if not nil
+	Events *Events
+}
+
+// Walk all the nodes, apply Action and wait for it to finish. Walk nodes in parallel, if parents ("needs") are done.
+// If an action fails, the context passed to the running actions is
+// cancelled and no more actions are started.
+func Walk(ctx context.Context, plan Components, action Action) error {
+	return (&Walker{}).Walk(ctx, plan, action)
 }
 
 // ReverseWalk all the nodes, apply Action and wait for it to finish. Walk nodes in parallel, blocks if node still has running needers
-func ReverseWalk(ctx context.Context, plan Components, action Action) {
-	done := map[DeploymentID]bool{}
-	running := map[DeploymentID]bool{}
+// If an action fails, the context passed to the running actions is
+// cancelled and no more actions are started.
+func ReverseWalk(ctx context.Context, plan Components, action Action) error {
+	return (&Walker{}).ReverseWalk(ctx, plan, action)
+}
+
+// Walk applies the action to a component after all its needs are done
+func (w *Walker) Walk(ctx context.Context, plan Components, action Action) error {
+	return w.run(ctx, plan, action, needs(plan))
+}
+
+// ReverseWalk applies the action to a component after all components,
+// which need it, are done
+func (w *Walker) ReverseWalk(ctx context.Context, plan Components, action Action) error {
+	return w.run(ctx, plan, action, needers(plan))
+}
+
+// needs returns the components each component waits for in Walk
+func needs(plan Components) map[DeploymentID][]DeploymentID {
+	deps := map[DeploymentID][]DeploymentID{}
 	for _, c := range plan {
-		done[c.ID] = false
-		running[c.ID] = false
+		deps[c.ID] = c.Needs
 	}
+	return deps
+}
 
-	needers := map[DeploymentID][]DeploymentID{}
+// needers returns the components each component waits for in ReverseWalk
+func needers(plan Components) map[DeploymentID][]DeploymentID {
+	deps := map[DeploymentID][]DeploymentID{}
 	for _, c := range plan {
-		if c.Needs != "" {
-			needers[c.Needs] = append(needers[c.Needs], c.ID)
+		for _, n := range c.Needs {
+			deps[n] = append(deps[n], c.ID)
 		}
 	}
+	return deps
+}
 
-	wg := &sync.WaitGroup{}
-	var lock = &sync.RWMutex{}
-	for !allDone(lock, done) {
-		for _, c := range plan {
-			c := c
-			lock.RLock()
-			if done[c.ID] {
-				lock.RUnlock()
-				continue
-			}
-			if running[c.ID] {
-				lock.RUnlock()
-				continue
-			}
-			if len(needers[c.ID]) > 0 {
-				blocked := false
-				for _, n := range needers[c.ID] {
-					if !done[n] {
-						blocked = true
-						break
-					}
-
-				}
-				if blocked {
-					lock.RUnlock()
-					continue
-				}
-			}
-			lock.RUnlock()
+// result is sent by an action's goroutine when it finishes
+type result struct {
+	id  DeploymentID
+	err error
+}
+
+// run starts the action for each component, once all its deps are done.
+// It only wakes up when an action finishes. Components with deps that
+// never finish, e.g. because they are missing, are skipped.
+func (w *Walker) run(ctx context.Context, plan Components, action Action, deps map[DeploymentID][]DeploymentID) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// index keeps ready components in plan order
+	index := map[DeploymentID]int{}
+	for i, c := range plan {
+		index[c.ID] = i
+	}
+
+	// waiting counts the unfinished deps, dependents is the reverse edge
+	waiting := map[DeploymentID]int{}
+	dependents := map[DeploymentID][]DeploymentID{}
+	ready := []int{}
+	for i, c := range plan {
+		waiting[c.ID] = len(deps[c.ID])
+		for _, d := range deps[c.ID] {
+			dependents[d] = append(dependents[d], c.ID)
+		}
+		if waiting[c.ID] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	for _, c := range plan {
+		w.Events.Emit(Event{Type: EventQueued, Component: c.ID})
+	}
+
+	done := map[DeploymentID]bool{}
+	failed := map[DeploymentID]error{}
+	started := map[DeploymentID]time.Time{}
+	results := make(chan result)
+	running := 0
+
+	for {
+		// start ready components, unless something failed or the walk was cancelled
+		for len(ready) > 0 && len(failed) == 0 && ctx.Err() == nil && (w.Parallel <= 0 || running < w.Parallel) {
+			c := plan[ready[0]]
+			ready = ready[1:]
+			running++
+			started[c.ID] = time.Now()
+			w.Events.Emit(Event{Type: EventStarted, Component: c.ID})
+
+			go func(c Component) {
+				results <- result{id: c.ID, err: action.Apply(ctx, c)}
+			}(c)
+		}
+
+		if running == 0 {
+			break
+		}
 
-			lock.Lock()
-			running[c.ID] = true
-			lock.Unlock()
+		r := <-results
+		running--
 
-			wg.Add(1)
-			go func(wg *sync.WaitGroup) {
-				defer wg.Done()
+		ev := Event{Type: EventFinished, Component: r.id, Status: StatusDone, Duration: Duration(time.Since(started[r.id]))}
+		if r.err != nil {
+			ev.Status = StatusFailed
+			ev.Error = r.err.Error()
+		}
+		w.Events.Emit(ev)
 
-				if err := action.Apply(ctx, c); err != nil {
-					fmt.Println(err)
-					os.Exit(-1)
-				}
+		if r.err != nil {
+			failed[r.id] = r.err
+			// abort running actions
+			cancel()
+			continue
+		}
+
+		done[r.id] = true
+		for _, d := range dependents[r.id] {
+			waiting[d]--
+			if waiting[d] == 0 {
+				ready = insertSorted(ready, index[d])
+			}
+		}
+	}
 
-				lock.Lock()
-				done[c.ID] = true
-				lock.Unlock()
-			}(wg)
+	err := walkError(plan, done, failed)
+	if werr, ok := err.(*WalkError); ok {
+		for _, id := range werr.Skipped {
+			w.Events.Emit(Event{Type: EventSkipped, Component: id})
 		}
 	}
+	return err
+}
 
-	wg.Wait()
+// insertSorted inserts i into the sorted list
+func insertSorted(list []int, i int) []int {
+	pos := sort.SearchInts(list, i)
+	list = append(list, 0)
+	copy(list[pos+1:], list[pos:])
+	list[pos] = i
+	return list
 }
 
-func allDone(lock *sync.RWMutex, s map[DeploymentID]bool) bool {
-	lock.RLock()
-	defer lock.RUnlock()
-	for _, done := range s {
-		if !done {
-			return false
+// walkError returns a WalkError if any component is not done, or nil.
+// Components without an error are reported as skipped.
+func walkError(plan Components, done map[DeploymentID]bool, failed map[DeploymentID]error) error {
+	werr := &WalkError{}
+	for _, c := range plan {
+		if done[c.ID] {
+			continue
 		}
+		if err, ok := failed[c.ID]; ok {
+			werr.Failed = append(werr.Failed, ComponentError{ID: c.ID, Err: err})
+		} else {
+			werr.Skipped = append(werr.Skipped, c.ID)
+		}
+	}
+
+	if len(werr.Failed) == 0 && len(werr.Skipped) == 0 {
+		return nil
 	}
-	return true
+	return werr
 }
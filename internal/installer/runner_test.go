@@ -1,7 +1,10 @@
 package installer_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"sync"
 	"time"
 
@@ -47,6 +50,90 @@ func (e errspy) Apply(ctx context.Context, c installer.Component) error {
 	return nil
 }
 
+// cancelspy fails on one component, others block until their context is cancelled
+type cancelspy struct {
+	Fail string
+}
+
+var _ installer.Action = &cancelspy{}
+
+func (s cancelspy) Apply(ctx context.Context, c installer.Component) error {
+	if c.String() == s.Fail {
+		return errors.New(s.Fail + " failed")
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(10 * time.Second):
+		return nil
+	}
+}
+
+// concurrencyspy holds each action until Want actions are running at the
+// same time, and records the maximum number of running actions
+type concurrencyspy struct {
+	lock    sync.Mutex
+	full    chan struct{}
+	Want    int
+	running int
+	Max     int
+}
+
+var _ installer.Action = &concurrencyspy{}
+
+func newConcurrencyspy(want int) *concurrencyspy {
+	return &concurrencyspy{Want: want, full: make(chan struct{})}
+}
+
+func (s *concurrencyspy) Apply(ctx context.Context, c installer.Component) error {
+	s.lock.Lock()
+	s.running++
+	if s.running > s.Max {
+		s.Max = s.running
+		if s.Max == s.Want {
+			close(s.full)
+		}
+	}
+	s.lock.Unlock()
+
+	defer func() {
+		s.lock.Lock()
+		s.running--
+		s.lock.Unlock()
+	}()
+
+	select {
+	case <-s.full:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(10 * time.Second):
+		return fmt.Errorf("%s: less than %d actions running", c, s.Want)
+	}
+}
+
+type orderspy struct {
+	Order *[]string
+}
+
+var _ installer.Action = &orderspy{}
+
+func (o orderspy) Apply(ctx context.Context, c installer.Component) error {
+	lock.Lock()
+	defer lock.Unlock()
+	*o.Order = append(*o.Order, c.String())
+	return nil
+}
+
+func indexOf(list []string, s string) int {
+	for i, e := range list {
+		if e == s {
+			return i
+		}
+	}
+	return -1
+}
+
 var _ = Describe("Runner", func() {
 	Describe("Walk", func() {
 		var m *installer.Manifest
@@ -67,14 +154,158 @@ var _ = Describe("Runner", func() {
 			}
 		})
 
+		It("visits components after all their needs", func() {
+			order := []string{}
+			err := installer.Walk(context.TODO(), m.Components, &orderspy{Order: &order})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(order).To(HaveLen(len(m.Components)))
+			Expect(indexOf(order, "epinio")).To(BeNumerically(">", indexOf(order, "tekton")))
+			Expect(indexOf(order, "epinio")).To(BeNumerically(">", indexOf(order, "cert-manager")))
+		})
+
+		It("runs independent components in parallel", func() {
+			cs := installer.Components{{ID: "a"}, {ID: "b"}, {ID: "c"}, {ID: "d", Needs: installer.DeploymentIDs{"a", "b", "c"}}}
+			s := newConcurrencyspy(3)
+			err := installer.Walk(context.TODO(), cs, s)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.Max).To(Equal(3))
+		})
+
+		It("limits the number of parallel actions", func() {
+			cs := installer.Components{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+			s := newConcurrencyspy(2)
+			w := &installer.Walker{Parallel: 2}
+			err := w.Walk(context.TODO(), cs, s)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.Max).To(Equal(2))
+		})
+
+		It("keeps the order of needs with limited parallelism", func() {
+			order := []string{}
+			w := &installer.Walker{Parallel: 1}
+			err := w.Walk(context.TODO(), m.Components, &orderspy{Order: &order})
+			Expect(err).ToNot(HaveOccurred())
+			// serialized, the walk follows the plan
+			plan, err := installer.BuildPlan(m.Components)
+			Expect(err).ToNot(HaveOccurred())
+			ids := []string{}
+			for _, id := range plan.IDs() {
+				ids = append(ids, string(id))
+			}
+			Expect(order).To(Equal(ids))
+		})
+
+		It("skips components with missing needs", func() {
+			cs := installer.Components{{ID: "a"}, {ID: "b", Needs: installer.DeploymentIDs{"missing"}}}
+			order := []string{}
+			err := installer.Walk(context.TODO(), cs, &orderspy{Order: &order})
+			Expect(err).To(MatchError(ContainSubstring("skipped: b")))
+			Expect(order).To(Equal([]string{"a"}))
+		})
+
 		It("does not start new actions after err", func() {
-			// should check it doesn't cancel running actions
 			s := &errspy{Visited: map[string]bool{}}
 			err := installer.Walk(context.TODO(), m.Components, s)
 			Expect(err).To(HaveOccurred())
+
+			werr, ok := err.(*installer.WalkError)
+			Expect(ok).To(BeTrue())
+			Expect(werr.Failed).To(HaveLen(1))
+			Expect(werr.Failed[0].ID).To(Equal(installer.DeploymentID("linkerd")))
+			Expect(werr.Skipped).To(HaveLen(8))
+			Expect(werr.Error()).To(ContainSubstring("failed 'linkerd': error on first component"))
+
 			Expect(s.Visited).To(HaveLen(2))
 			Expect(s.Visited).To(HaveKeyWithValue("epinio-namespace", true))
 			Expect(s.Visited).To(HaveKeyWithValue("linkerd", true))
 		})
+
+		It("cancels running actions after err", func() {
+			start := time.Now()
+			err := installer.Walk(context.TODO(), m.Components, &cancelspy{Fail: "linkerd"})
+			Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+
+			werr, ok := err.(*installer.WalkError)
+			Expect(ok).To(BeTrue())
+			Expect(werr.Failed).To(ConsistOf(
+				installer.ComponentError{ID: "epinio-namespace", Err: context.Canceled},
+				installer.ComponentError{ID: "linkerd", Err: errors.New("linkerd failed")},
+			))
+			Expect(werr.Skipped).To(HaveLen(8))
+		})
+	})
+
+	Describe("Events", func() {
+		It("reports the progress of the components", func() {
+			cs := installer.Components{{ID: "a"}, {ID: "b", Needs: installer.DeploymentIDs{"a"}}, {ID: "c", Needs: installer.DeploymentIDs{"b"}}}
+
+			var b bytes.Buffer
+			w := &installer.Walker{Events: installer.NewEvents(&b, installer.NewRedactor())}
+			err := w.Walk(context.TODO(), cs, &failspy{Fail: "b"})
+			Expect(err).To(HaveOccurred())
+
+			events := []installer.Event{}
+			dec := json.NewDecoder(&b)
+			for dec.More() {
+				ev := installer.Event{}
+				Expect(dec.Decode(&ev)).To(Succeed())
+				Expect(ev.Time).ToNot(BeZero())
+				events = append(events, ev)
+			}
+
+			types := []string{}
+			for _, ev := range events {
+				types = append(types, fmt.Sprintf("%s %s %s", ev.Type, ev.Component, ev.Status))
+			}
+			Expect(types).To(Equal([]string{
+				"queued a ",
+				"queued b ",
+				"queued c ",
+				"started a ",
+				"finished a done",
+				"started b ",
+				"finished b failed",
+				"skipped c ",
+			}))
+			Expect(events[6].Error).To(Equal("b failed"))
+		})
+	})
+
+	Describe("ReverseWalk", func() {
+		It("visits components before all their needs", func() {
+			m, err := installer.Load(assetPath("test-manifest.yml"))
+			Expect(err).ToNot(HaveOccurred())
+
+			order := []string{}
+			err = installer.ReverseWalk(context.TODO(), m.Components, &orderspy{Order: &order})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(order).To(HaveLen(len(m.Components)))
+			Expect(indexOf(order, "epinio")).To(BeNumerically("<", indexOf(order, "tekton")))
+			Expect(indexOf(order, "epinio")).To(BeNumerically("<", indexOf(order, "cert-manager")))
+		})
+
+		It("returns all errors instead of exiting", func() {
+			m, err := installer.Load(assetPath("test-manifest.yml"))
+			Expect(err).ToNot(HaveOccurred())
+
+			start := time.Now()
+			err = installer.ReverseWalk(context.TODO(), m.Components, &cancelspy{Fail: "epinio"})
+			Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+
+			werr, ok := err.(*installer.WalkError)
+			Expect(ok).To(BeTrue())
+			Expect(werr.Failed).To(ContainElement(installer.ComponentError{ID: "epinio", Err: errors.New("epinio failed")}))
+			Expect(werr.Failed).To(ContainElement(installer.ComponentError{ID: "kubed", Err: context.Canceled}))
+			Expect(werr.Skipped).To(ContainElement(installer.DeploymentID("linkerd")))
+		})
+
+		It("limits the number of parallel actions", func() {
+			cs := installer.Components{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+			s := newConcurrencyspy(2)
+			w := &installer.Walker{Parallel: 2}
+			err := w.ReverseWalk(context.TODO(), cs, s)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.Max).To(Equal(2))
+		})
 	})
 })
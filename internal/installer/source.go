@@ -0,0 +1,172 @@
+package installer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Sources locates the files components are installed from: embedded
+// files and checkouts of git repositories. Without assets, embedded paths
+// can't be read, without a git cache, git sources can't be checked out.
+type Sources struct {
+	assets *Assets
+	git    *GitCache
+}
+
+// NewSources returns the sources, either may be nil
+func NewSources(assets *Assets, git *GitCache) *Sources {
+	return &Sources{assets: assets, git: git}
+}
+
+// Cleanup removes the extracted embedded files
+func (s *Sources) Cleanup() error {
+	if s == nil || s.assets == nil {
+		return nil
+	}
+	return s.assets.Cleanup()
+}
+
+// read reads the YAML of the component from its path, git repository or
+// URL, and verifies its checksum
+func (s *Sources) read(ctx context.Context, c Component) ([]byte, error) {
+	var data []byte
+	var err error
+	if c.Source.Path == "" && c.Source.Git == nil && c.Source.URL != "" {
+		data, err = download(ctx, c.Source.URL)
+	} else {
+		var path string
+		path, err = s.path(ctx, c)
+		if err == nil {
+			data, err = s.readFile(path)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifySHA256(c.Source.location(), data, c.Source.SHA256); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// path returns the local path of the component's chart or YAML file, git
+// sources are checked out first
+func (s *Sources) path(ctx context.Context, c Component) (string, error) {
+	if c.Source.Git == nil {
+		return c.Source.Path, nil
+	}
+	if s == nil || s.git == nil {
+		return "", fmt.Errorf("no git cache to check out %s", c.Source.Git)
+	}
+	return s.git.Checkout(ctx, *c.Source.Git)
+}
+
+// local returns the path on disk of a component's file, paths which are
+// not embedded are returned unchanged
+func (s *Sources) local(p string) (string, error) {
+	if !IsEmbedded(p) {
+		return p, nil
+	}
+	if s == nil || s.assets == nil {
+		return "", fmt.Errorf("no embedded files to read '%s' from", p)
+	}
+	return s.assets.Path(p)
+}
+
+// readFile reads a local or embedded file
+func (s *Sources) readFile(path string) ([]byte, error) {
+	local, err := s.local(path)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(local)
+}
+
+const (
+	// downloadTimeout limits downloading a YAML source, including its body
+	downloadTimeout = 5 * time.Minute
+
+	// maxDownloadSize limits the size of a downloaded YAML source
+	maxDownloadSize = 64 << 20
+)
+
+// downloadClient downloads YAML sources
+var downloadClient = &http.Client{Timeout: downloadTimeout}
+
+// download returns the body of the HTTP(S) URL, it fails if the body is
+// larger than maxDownloadSize
+func download(ctx context.Context, url string) ([]byte, error) {
+	if !isHTTPURL(url) {
+		return nil, fmt.Errorf("unsupported URL '%s', needs http or https", url)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := downloadClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to download '%s'", url)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download '%s': %s", url, resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxDownloadSize+1))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to download '%s'", url)
+	}
+	if len(b) > maxDownloadSize {
+		return nil, fmt.Errorf("failed to download '%s': larger than %d bytes", url, maxDownloadSize)
+	}
+	return b, nil
+}
+
+// isHTTPURL is true for http:// and https:// URLs
+func isHTTPURL(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
+
+// verifySHA256 compares the checksum of data with the expected hex
+// digest, an empty digest is not verified
+func verifySHA256(name string, data []byte, expected string) error {
+	if expected == "" {
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	if actual := hex.EncodeToString(sum[:]); actual != strings.ToLower(expected) {
+		return fmt.Errorf("sha256 of '%s' is %s, expected %s", name, actual, expected)
+	}
+	return nil
+}
+
+// verifyFileSHA256 verifies the checksum of the file at path, it fails
+// for directories
+func verifyFileSHA256(path string, expected string) error {
+	if expected == "" {
+		return nil
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("sha256 can't be verified for directory '%s', only for chart archives", path)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return verifySHA256(path, b, expected)
+}
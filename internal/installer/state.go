@@ -0,0 +1,409 @@
+package installer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+type ComponentStatus string
+
+const (
+	StatusPending ComponentStatus = "pending"
+	StatusRunning ComponentStatus = "running"
+	StatusDone    ComponentStatus = "done"
+	StatusFailed  ComponentStatus = "failed"
+
+	// StateConfigMap is the name of the config map holding the install state
+	StateConfigMap = "epinio-installer-state"
+)
+
+// ComponentState is the recorded progress of a component
+type ComponentState struct {
+	Status ComponentStatus `json:"status"`
+
+	// Hash of the component's definition, when it was last processed
+	Hash string `json:"hash"`
+
+	// Definition summarizes the hashed definition, to explain changes
+	Definition *Definition `json:"definition,omitempty"`
+
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Error    string     `json:"error,omitempty"`
+
+	// Objects were applied by a YAML component, they are kept across
+	// runs to find objects, which were removed from its source
+	Objects []ObjectRef `json:"objects,omitempty"`
+}
+
+// ObjectRef identifies an object in the cluster
+type ObjectRef struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+}
+
+func newObjectRef(obj *unstructured.Unstructured) ObjectRef {
+	return ObjectRef{
+		APIVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+	}
+}
+
+// object returns an object with the ref's type and name
+func (r ObjectRef) object() *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(r.APIVersion)
+	obj.SetKind(r.Kind)
+	obj.SetNamespace(r.Namespace)
+	obj.SetName(r.Name)
+	return obj
+}
+
+// Definition is the source, version and the digests of the source and of
+// the values of a component
+type Definition struct {
+	Source       string `json:"source,omitempty"`
+	SourceDigest string `json:"source_digest,omitempty"`
+	Version      string `json:"version,omitempty"`
+	Values       string `json:"values,omitempty"`
+}
+
+// State is the recorded progress of all components
+type State map[DeploymentID]ComponentState
+
+// Hash returns a hash of the whole component, used by 'install --resume'
+// to find changed components. After ReadContents it includes the digests of the files the
+// component is installed from and of the variables used by templates,
+// all other fields have the variables expanded already. Before, all
+// variables are hashed.
+func (c Component) Hash() string {
+	b, _ := json.Marshal(struct {
+		Component
+		Contents *contents `json:"contents,omitempty"`
+	}{c, c.fileContents()})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Definition returns the summary of the component's definition, upgrade
+// only changes components whose definition changed. The values
+// digest includes the values files' contents, the values object and the
+// variables used by templates, the source digest the contents of local
+// and git sources, both after ReadContents.
+func (c Component) Definition() Definition {
+	s := c.Source
+	source := s.location()
+	switch {
+	case s.IsOCI():
+		source = s.Chart
+	case s.IsHelmRef():
+		source = s.URL + " " + s.Chart
+	}
+
+	version := s.Version
+	if s.Digest != "" {
+		version = strings.TrimPrefix(version+"@"+s.Digest, "@")
+	}
+
+	ct := c.fileContents()
+	b, _ := json.Marshal(struct {
+		Values       Values
+		ValuesFiles  []string
+		ValuesObject ValuesObject
+		Contents     []string
+		Vars         Variables
+	}{c.Values, c.ValuesFiles, c.ValuesObject, ct.ValuesFiles, ct.Vars})
+	sum := sha256.Sum256(b)
+
+	var sourceDigest string
+	if ct.Source != "" {
+		sourceDigest = ct.Source[:16]
+	}
+
+	return Definition{
+		Source:       source,
+		SourceDigest: sourceDigest,
+		Version:      version,
+		Values:       hex.EncodeToString(sum[:8]),
+	}
+}
+
+// fileContents returns the contents read by ReadContents. Without them,
+// all variables are assumed to be used.
+func (c Component) fileContents() *contents {
+	if c.contents != nil {
+		return c.contents
+	}
+	return &contents{Vars: c.Vars}
+}
+
+// StateStore persists the state in a config map in the cluster, each
+// component is stored as JSON under its ID
+type StateStore struct {
+	client    kubernetes.Interface
+	namespace string
+	lock      *sync.Mutex
+}
+
+// NewStateStore returns a store for the state config map in namespace
+func NewStateStore(client kubernetes.Interface, namespace string) *StateStore {
+	return &StateStore{
+		client:    client,
+		namespace: namespace,
+		lock:      &sync.Mutex{},
+	}
+}
+
+// Load returns the stored state, which is empty if nothing was stored yet
+func (s *StateStore) Load(ctx context.Context) (State, error) {
+	state := State{}
+
+	cm, err := s.client.CoreV1().ConfigMaps(s.namespace).Get(ctx, StateConfigMap, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load install state")
+	}
+
+	for id, data := range cm.Data {
+		cs := ComponentState{}
+		if err := json.Unmarshal([]byte(data), &cs); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse install state of '%s'", id)
+		}
+		state[DeploymentID(id)] = cs
+	}
+	return state, nil
+}
+
+// Update modifies the stored state of a single component, which is empty
+// if nothing was stored yet
+func (s *StateStore) Update(ctx context.Context, id DeploymentID, modify func(*ComponentState)) error {
+	return s.update(ctx, func(data map[string]string) error {
+		cs := ComponentState{}
+		if prev, ok := data[string(id)]; ok {
+			if err := json.Unmarshal([]byte(prev), &cs); err != nil {
+				return errors.Wrapf(err, "failed to parse install state of '%s'", id)
+			}
+		}
+		modify(&cs)
+
+		b, err := json.Marshal(cs)
+		if err != nil {
+			return err
+		}
+		data[string(id)] = string(b)
+		return nil
+	})
+}
+
+// Remove deletes the state of a single component
+func (s *StateStore) Remove(ctx context.Context, id DeploymentID) error {
+	return s.update(ctx, func(data map[string]string) error {
+		delete(data, string(id))
+		return nil
+	})
+}
+
+// RecordObjects adds the objects applied by a YAML component to its
+// state. Objects of earlier runs are kept, as they are not deleted when
+// they are removed from the component's source.
+func (s *StateStore) RecordObjects(ctx context.Context, id DeploymentID, refs []ObjectRef) error {
+	return s.Update(ctx, id, func(cs *ComponentState) {
+		seen := map[ObjectRef]bool{}
+		for _, ref := range cs.Objects {
+			seen[ref] = true
+		}
+		for _, ref := range refs {
+			if !seen[ref] {
+				seen[ref] = true
+				cs.Objects = append(cs.Objects, ref)
+			}
+		}
+	})
+}
+
+// update modifies the config map's data, creating the namespace and
+// config map if needed. Updates are serialized and retried on conflicts.
+func (s *StateStore) update(ctx context.Context, modify func(map[string]string) error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	cms := s.client.CoreV1().ConfigMaps(s.namespace)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := cms.Get(ctx, StateConfigMap, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			if err := s.createNamespace(ctx); err != nil {
+				return err
+			}
+
+			cm = &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      StateConfigMap,
+					Namespace: s.namespace,
+				},
+				Data: map[string]string{},
+			}
+			if err := modify(cm.Data); err != nil {
+				return err
+			}
+			_, err = cms.Create(ctx, cm, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		if err := modify(cm.Data); err != nil {
+			return err
+		}
+		_, err = cms.Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+
+	return errors.Wrap(err, "failed to save install state")
+}
+
+func (s *StateStore) createNamespace(ctx context.Context) error {
+	_, err := s.client.CoreV1().Namespaces().Create(ctx, &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: s.namespace},
+	}, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
+// Stateful is an action, which records the progress of the wrapped
+// action in the state store
+type Stateful struct {
+	action    Action
+	store     *StateStore
+	log       logr.Logger
+	uninstall bool
+
+	// skip lists the components, which are done and don't need to run again
+	skip map[DeploymentID]bool
+}
+
+var _ Action = &Stateful{}
+
+// NewStateful wraps the action. When uninstalling, the state of a
+// component is removed after it is uninstalled.
+func NewStateful(action Action, store *StateStore, log logr.Logger, uninstall bool) *Stateful {
+	return &Stateful{
+		action:    action,
+		store:     store,
+		log:       log,
+		uninstall: uninstall,
+		skip:      map[DeploymentID]bool{},
+	}
+}
+
+// Prepare marks all components of the plan as pending. If resume is
+// true, components which are done and whose definition didn't change,
+// will be skipped.
+func (s *Stateful) Prepare(ctx context.Context, plan Components, resume bool) error {
+	previous, err := s.store.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.prepare(ctx, plan, func(c Component) bool {
+		cs, ok := previous[c.ID]
+		return resume && ok && cs.Status == StatusDone && cs.Hash == c.Hash()
+	})
+}
+
+// PrepareUpgrade marks the components, which the upgrade changes, as
+// pending. Unchanged components will be skipped and keep their state.
+func (s *Stateful) PrepareUpgrade(ctx context.Context, plan Components, up UpgradePlan) error {
+	unchanged := map[DeploymentID]bool{}
+	for _, item := range up {
+		unchanged[item.ID] = item.Change == Unchanged
+	}
+	return s.prepare(ctx, plan, func(c Component) bool {
+		return unchanged[c.ID]
+	})
+}
+
+func (s *Stateful) prepare(ctx context.Context, plan Components, skip func(Component) bool) error {
+	for _, c := range plan {
+		if skip(c) {
+			s.skip[c.ID] = true
+			continue
+		}
+
+		hash, def := c.Hash(), c.Definition()
+		err := s.store.Update(ctx, c.ID, func(cs *ComponentState) {
+			*cs = ComponentState{Status: StatusPending, Hash: hash, Definition: &def, Objects: cs.Objects}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Stateful) Apply(ctx context.Context, c Component) error {
+	if s.skip[c.ID] {
+		s.log.Info("skip, already done", "component", c.ID)
+		return nil
+	}
+
+	started := time.Now()
+	hash, def := c.Hash(), c.Definition()
+	err := s.store.Update(ctx, c.ID, func(cs *ComponentState) {
+		*cs = ComponentState{Status: StatusRunning, Hash: hash, Definition: &def, Started: &started, Objects: cs.Objects}
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.action.Apply(ctx, c)
+
+	finished := time.Now()
+	if err != nil {
+		// the walk's context might be cancelled already. Diagnostics are
+		// left to the returned error, they might not fit into the state.
+		serr := s.store.Update(context.Background(), c.ID, func(cs *ComponentState) {
+			cs.Status = StatusFailed
+			cs.Finished = &finished
+			cs.Error = withoutReport(err)
+		})
+		if serr != nil {
+			s.log.Error(serr, "failed to record failure", "component", c.ID)
+		}
+		return err
+	}
+
+	if s.uninstall {
+		return s.store.Remove(ctx, c.ID)
+	}
+
+	return s.store.Update(ctx, c.ID, func(cs *ComponentState) {
+		cs.Status = StatusDone
+		cs.Finished = &finished
+	})
+}
@@ -0,0 +1,207 @@
+package installer_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/epinio/installer/internal/installer"
+)
+
+// failspy fails on one component, with Err if set, and succeeds
+// immediately on all others
+type failspy struct {
+	Fail string
+	Err  error
+}
+
+var _ installer.Action = &failspy{}
+
+func (s failspy) Apply(ctx context.Context, c installer.Component) error {
+	if c.String() == s.Fail {
+		if s.Err != nil {
+			return s.Err
+		}
+		return errors.New(s.Fail + " failed")
+	}
+	return nil
+}
+
+var _ = Describe("State", func() {
+	var client *fake.Clientset
+	var store *installer.StateStore
+	var m *installer.Manifest
+
+	BeforeEach(func() {
+		client = fake.NewSimpleClientset()
+		store = installer.NewStateStore(client, "epinio-installer")
+
+		var err error
+		m, err = installer.Load(assetPath("test-manifest.yml"))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("records the status of each component in a config map", func() {
+		act := installer.NewStateful(&cancelspy{Fail: "linkerd"}, store, logr.Discard(), false)
+		Expect(act.Prepare(context.TODO(), m.Components, false)).To(Succeed())
+
+		err := installer.Walk(context.TODO(), m.Components, act)
+		Expect(err).To(HaveOccurred())
+
+		_, err = client.CoreV1().Namespaces().Get(context.TODO(), "epinio-installer", metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		state, err := store.Load(context.TODO())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(state).To(HaveLen(len(m.Components)))
+
+		Expect(state["linkerd"].Status).To(Equal(installer.StatusFailed))
+		Expect(state["linkerd"].Error).To(Equal("linkerd failed"))
+		Expect(state["linkerd"].Started).ToNot(BeNil())
+		Expect(state["linkerd"].Finished).ToNot(BeNil())
+		Expect(state["epinio-namespace"].Status).To(Equal(installer.StatusFailed))
+		Expect(state["epinio-namespace"].Error).To(Equal(context.Canceled.Error()))
+		Expect(state["traefik"].Status).To(Equal(installer.StatusPending))
+		Expect(state["traefik"].Hash).To(Equal(m.Components[2].Hash()))
+	})
+
+	It("resumes by skipping unchanged components which are done", func() {
+		first := installer.NewStateful(&errspy{Visited: map[string]bool{}}, store, logr.Discard(), false)
+		Expect(first.Prepare(context.TODO(), m.Components, false)).To(Succeed())
+		Expect(installer.Walk(context.TODO(), m.Components, first)).ToNot(Succeed())
+
+		state, err := store.Load(context.TODO())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(state["epinio-namespace"].Status).To(Equal(installer.StatusDone))
+
+		// change a component, which was not installed yet
+		m.Components[2].Namespace = "traefik-v2"
+
+		s := &spy{Visited: map[string]bool{}}
+		second := installer.NewStateful(s, store, logr.Discard(), false)
+		Expect(second.Prepare(context.TODO(), m.Components, true)).To(Succeed())
+		Expect(installer.Walk(context.TODO(), m.Components, second)).To(Succeed())
+
+		Expect(s.Visited).ToNot(HaveKey("epinio-namespace"))
+		Expect(s.Visited).To(HaveKey("linkerd"))
+		Expect(s.Visited).To(HaveKey("traefik"))
+		Expect(s.Visited).To(HaveLen(len(m.Components) - 1))
+
+		state, err = store.Load(context.TODO())
+		Expect(err).ToNot(HaveOccurred())
+		for _, c := range m.Components {
+			Expect(state[c.ID].Status).To(Equal(installer.StatusDone))
+		}
+	})
+
+	It("reruns changed components", func() {
+		first := installer.NewStateful(&spy{Visited: map[string]bool{}}, store, logr.Discard(), false)
+		Expect(first.Prepare(context.TODO(), m.Components, false)).To(Succeed())
+		Expect(installer.Walk(context.TODO(), m.Components, first)).To(Succeed())
+
+		m.Components[0].Namespace = "epinio-v2"
+
+		s := &spy{Visited: map[string]bool{}}
+		second := installer.NewStateful(s, store, logr.Discard(), false)
+		Expect(second.Prepare(context.TODO(), m.Components, true)).To(Succeed())
+		Expect(installer.Walk(context.TODO(), m.Components, second)).To(Succeed())
+
+		Expect(s.Visited).To(Equal(map[string]bool{"epinio-namespace": true}))
+	})
+
+	It("reruns components, whose files changed", func() {
+		dir, err := ioutil.TempDir("", "epinio-state")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		source := filepath.Join(dir, "issuer.yaml")
+		values := filepath.Join(dir, "values.yaml")
+		Expect(ioutil.WriteFile(source, []byte("kind: ClusterIssuer\n"), 0600)).To(Succeed())
+		Expect(ioutil.WriteFile(values, []byte("greeting: hi\n"), 0600)).To(Succeed())
+
+		cs := installer.Components{
+			{ID: "issuer", Type: installer.YAML, Source: installer.Source{Path: source}},
+			{ID: "hello", Type: installer.Helm, Source: installer.Source{Name: "hello", Path: assetPath("charts/hello")}, ValuesFiles: []string{values}},
+		}
+		run := func() map[string]bool {
+			Expect(cs.ReadContents(context.TODO(), nil)).To(Succeed())
+			s := &spy{Visited: map[string]bool{}}
+			act := installer.NewStateful(s, store, logr.Discard(), false)
+			Expect(act.Prepare(context.TODO(), cs, true)).To(Succeed())
+			Expect(installer.Walk(context.TODO(), cs, act)).To(Succeed())
+			return s.Visited
+		}
+
+		Expect(run()).To(HaveLen(2))
+		Expect(run()).To(BeEmpty())
+
+		Expect(ioutil.WriteFile(source, []byte("kind: Issuer\n"), 0600)).To(Succeed())
+		Expect(run()).To(Equal(map[string]bool{"issuer": true}))
+
+		Expect(ioutil.WriteFile(values, []byte("greeting: hey\n"), 0600)).To(Succeed())
+		Expect(run()).To(Equal(map[string]bool{"hello": true}))
+	})
+
+	It("keeps the objects recorded for YAML components across runs", func() {
+		flags := installer.ObjectRef{APIVersion: "v1", Kind: "ConfigMap", Namespace: "tekton-pipelines", Name: "feature-flags"}
+		old := installer.ObjectRef{APIVersion: "v1", Kind: "ConfigMap", Namespace: "tekton-pipelines", Name: "old-flags"}
+		Expect(store.RecordObjects(context.TODO(), "tekton", []installer.ObjectRef{flags, old})).To(Succeed())
+
+		act := installer.NewStateful(&spy{Visited: map[string]bool{}}, store, logr.Discard(), false)
+		Expect(act.Prepare(context.TODO(), m.Components, false)).To(Succeed())
+		Expect(installer.Walk(context.TODO(), m.Components, act)).To(Succeed())
+
+		ns := installer.ObjectRef{APIVersion: "v1", Kind: "Namespace", Name: "tekton-pipelines"}
+		Expect(store.RecordObjects(context.TODO(), "tekton", []installer.ObjectRef{ns, flags})).To(Succeed())
+
+		state, err := store.Load(context.TODO())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(state["tekton"].Status).To(Equal(installer.StatusDone))
+		Expect(state["tekton"].Objects).To(Equal([]installer.ObjectRef{flags, old, ns}))
+	})
+
+	It("records failed checks without their diagnostics", func() {
+		redactor := installer.NewRedactor()
+		redactor.Add("s3cretpw")
+		diagnosed := &installer.DiagnosedError{
+			Err:    errors.New("pod 'app=server' not ready"),
+			Report: "pod 'server' is Pending\n  log: using s3cretpw\n",
+		}
+		fail := redactor.RedactError(fmt.Errorf("component timed out: %w", diagnosed))
+
+		act := installer.NewStateful(&failspy{Fail: "linkerd", Err: fail}, store, logr.Discard(), false)
+		Expect(act.Prepare(context.TODO(), m.Components, false)).To(Succeed())
+		err := installer.Walk(context.TODO(), m.Components, act)
+		Expect(err).To(MatchError(ContainSubstring("pod 'server' is Pending")))
+
+		state, err := store.Load(context.TODO())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(state["linkerd"].Error).To(Equal("component timed out: pod 'app=server' not ready"))
+	})
+
+	It("removes the state of uninstalled components", func() {
+		first := installer.NewStateful(&spy{Visited: map[string]bool{}}, store, logr.Discard(), false)
+		Expect(first.Prepare(context.TODO(), m.Components, false)).To(Succeed())
+		Expect(installer.Walk(context.TODO(), m.Components, first)).To(Succeed())
+
+		act := installer.NewStateful(&failspy{Fail: "epinio"}, store, logr.Discard(), true)
+		err := installer.ReverseWalk(context.TODO(), m.Components, act)
+		Expect(err).To(HaveOccurred())
+
+		state, err := store.Load(context.TODO())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(state["epinio"].Status).To(Equal(installer.StatusFailed))
+		Expect(state["linkerd"].Status).To(Equal(installer.StatusDone))
+		Expect(state).ToNot(HaveKey(installer.DeploymentID("kubed")))
+	})
+})
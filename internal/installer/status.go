@@ -0,0 +1,220 @@
+package installer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/go-logr/logr"
+	"helm.sh/helm/v3/pkg/release"
+
+	"github.com/epinio/installer/internal/kubernetes"
+)
+
+// Health is the current state of a component in the cluster
+type Health struct {
+	ID        DeploymentID  `json:"id"`
+	Type      ComponentType `json:"type"`
+	Namespace string        `json:"namespace,omitempty"`
+
+	// Installed is true if the release, all objects or the namespace exist
+	Installed bool `json:"installed"`
+	// Healthy is true if the component is installed and its checks pass
+	Healthy bool `json:"healthy"`
+
+	Message string        `json:"message,omitempty"`
+	Checks  []CheckHealth `json:"checks,omitempty"`
+}
+
+// CheckHealth is the result of evaluating a wait complete check once
+type CheckHealth struct {
+	Check   string `json:"check"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+// HealthReport lists the health of all components, in manifest order
+type HealthReport []Health
+
+// Healthy is true if all components are healthy
+func (r HealthReport) Healthy() bool {
+	return len(r.Unhealthy()) == 0
+}
+
+// Unhealthy returns the IDs of the unhealthy components
+func (r HealthReport) Unhealthy() []DeploymentID {
+	ids := []DeploymentID{}
+	for _, h := range r {
+		if !h.Healthy {
+			ids = append(ids, h.ID)
+		}
+	}
+	return ids
+}
+
+// WriteTable writes a table with a row per component
+func (r HealthReport) WriteTable(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "COMPONENT\tTYPE\tNAMESPACE\tINSTALLED\tHEALTHY\tMESSAGE")
+	for _, h := range r {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%t\t%s\n", h.ID, h.Type, h.Namespace, h.Installed, h.Healthy, h.summary())
+	}
+	return w.Flush()
+}
+
+// WriteJSON writes the report as a JSON object, with the overall health
+// and the components
+func (r HealthReport) WriteJSON(out io.Writer) error {
+	components := r
+	if components == nil {
+		components = HealthReport{}
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Healthy    bool         `json:"healthy"`
+		Components HealthReport `json:"components"`
+	}{r.Healthy(), components})
+}
+
+// summary returns the component's message, followed by the messages of
+// the failed checks
+func (h Health) summary() string {
+	msgs := []string{}
+	if h.Message != "" {
+		msgs = append(msgs, h.Message)
+	}
+	for _, chk := range h.Checks {
+		if !chk.Passed {
+			msgs = append(msgs, fmt.Sprintf("%s: %s", chk.Check, chk.Message))
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Status finds out whether components are installed and healthy, without
+// changing the cluster
+type Status struct {
+	cluster  *kubernetes.Cluster
+	log      logr.Logger
+	ca       *ComponentActions
+	helm     *HelmClient
+	yaml     *YAMLClient
+	resolver *Resolver
+}
+
+// NewStatus returns the status checker, the component's files are read
+// from sources. Values with a source are read by the resolver to render
+// YAML templates, other components don't resolve their values.
+func NewStatus(cluster *kubernetes.Cluster, log logr.Logger, ca *ComponentActions, sources *Sources, resolver *Resolver) *Status {
+	return &Status{
+		cluster:  cluster,
+		log:      log,
+		ca:       ca,
+		helm:     NewHelmClient(cluster, sources),
+		yaml:     NewYAMLClient(cluster.Dynamic, cluster.Mapper, sources),
+		resolver: resolver,
+	}
+}
+
+// Report returns the health of all components
+func (s *Status) Report(ctx context.Context, components Components) HealthReport {
+	report := HealthReport{}
+	for _, c := range components {
+		report = append(report, s.Check(ctx, c))
+	}
+	return report
+}
+
+// Check returns the health of the component. Its wait complete checks
+// are evaluated once, if it is installed.
+func (s *Status) Check(ctx context.Context, c Component) Health {
+	h := Health{ID: c.ID, Type: c.Type, Namespace: c.Namespace}
+
+	// only YAML templates need the values, to render the objects to look
+	// for, so a deleted secret doesn't break other components
+	var err error
+	if c.Type == YAML {
+		c, err = s.resolver.Resolve(ctx, c)
+		if err != nil {
+			h.Message = s.resolver.redactor.Redact(err.Error())
+			return h
+		}
+	}
+
+	h.Installed, h.Message, err = s.installed(ctx, c)
+	if err != nil {
+		h.Message = s.resolver.redactor.Redact(err.Error())
+		return h
+	}
+	if !h.Installed {
+		return h
+	}
+
+	h.Healthy = h.Message == ""
+	for _, chk := range c.WaitComplete {
+		ok, msg, _ := s.ca.Check(ctx, c, chk)
+		h.Checks = append(h.Checks, CheckHealth{
+			Check:   checkSubject(chk),
+			Passed:  ok,
+			Message: s.resolver.redactor.Redact(msg),
+		})
+		h.Healthy = h.Healthy && ok
+	}
+	return h
+}
+
+// installed returns whether the component exists in the cluster. The
+// message explains what is missing, or why it's not healthy.
+func (s *Status) installed(ctx context.Context, c Component) (bool, string, error) {
+	switch c.Type {
+	case Helm:
+		status, err := s.helm.ReleaseStatus(ctx, s.log.V(1).WithName("helm"), c)
+		if err != nil {
+			return false, "", err
+		}
+		if status == "" {
+			return false, fmt.Sprintf("release '%s' not found", c.Source.Name), nil
+		}
+		if status != release.StatusDeployed {
+			return true, fmt.Sprintf("release '%s' is %s", c.Source.Name, status), nil
+		}
+		return true, "", nil
+
+	case YAML:
+		missing, err := s.yaml.Missing(ctx, c)
+		if err != nil {
+			return false, "", err
+		}
+		if len(missing) > 0 {
+			return false, "missing " + strings.Join(missing, ", "), nil
+		}
+		return true, "", nil
+
+	case Namespace:
+		ok, err := s.cluster.NamespaceExists(ctx, c.Namespace)
+		if err != nil {
+			return false, "", err
+		}
+		if !ok {
+			return false, fmt.Sprintf("namespace '%s' not found", c.Namespace), nil
+		}
+		return true, "", nil
+	}
+	return false, fmt.Sprintf("unknown component type '%s'", c.Type), nil
+}
+
+// checkSubject names the object a check looks at, e.g. "deployment 'server'"
+func checkSubject(chk ComponentAction) string {
+	if chk.Type == Condition {
+		if chk.Name != "" {
+			return fmt.Sprintf("%s '%s'", chk.Kind, chk.Name)
+		}
+		return fmt.Sprintf("%s with selector '%s'", chk.Kind, chk.Selector)
+	}
+	return fmt.Sprintf("%s '%s'", chk.Type, chk.Selector)
+}
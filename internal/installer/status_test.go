@@ -0,0 +1,196 @@
+package installer_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/go-logr/logr"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	"sigs.k8s.io/yaml"
+
+	"github.com/epinio/installer/internal/installer"
+)
+
+var _ = Describe("HealthReport", func() {
+	report := installer.HealthReport{
+		{ID: "epinio-namespace", Type: installer.Namespace, Namespace: "epinio", Installed: true, Healthy: true},
+		{ID: "cert-manager", Type: installer.Helm, Namespace: "cert-manager", Installed: true, Healthy: false,
+			Checks: []installer.CheckHealth{
+				{Check: "deployment 'cert-manager'", Passed: true},
+				{Check: "pod 'app=webhook'", Passed: false, Message: "pods are not ready"},
+			},
+		},
+		{ID: "linkerd", Type: installer.Helm, Namespace: "linkerd", Message: "release 'linkerd' not found"},
+	}
+
+	It("lists the unhealthy components", func() {
+		Expect(report.Healthy()).To(BeFalse())
+		Expect(report.Unhealthy()).To(Equal([]installer.DeploymentID{"cert-manager", "linkerd"}))
+		Expect(report[:1].Healthy()).To(BeTrue())
+	})
+
+	It("writes a table", func() {
+		var b bytes.Buffer
+		Expect(report.WriteTable(&b)).To(Succeed())
+		lines := strings.Split(b.String(), "\n")
+		Expect(lines).To(HaveLen(5))
+		Expect(lines[0]).To(Equal("COMPONENT         TYPE       NAMESPACE     INSTALLED  HEALTHY  MESSAGE"))
+		Expect(lines[1]).To(HavePrefix("epinio-namespace  namespace  epinio        true       true"))
+		Expect(lines[2]).To(Equal("cert-manager      helm       cert-manager  true       false    pod 'app=webhook': pods are not ready"))
+		Expect(lines[3]).To(Equal("linkerd           helm       linkerd       false      false    release 'linkerd' not found"))
+	})
+
+	It("writes JSON", func() {
+		var b bytes.Buffer
+		Expect(report.WriteJSON(&b)).To(Succeed())
+
+		out := struct {
+			Healthy    bool
+			Components []map[string]interface{}
+		}{}
+		Expect(json.Unmarshal(b.Bytes(), &out)).To(Succeed())
+		Expect(out.Healthy).To(BeFalse())
+		Expect(out.Components).To(HaveLen(3))
+		Expect(out.Components[1]).To(HaveKeyWithValue("id", "cert-manager"))
+		Expect(out.Components[1]).To(HaveKeyWithValue("checks", HaveLen(2)))
+		Expect(out.Components[2]).To(HaveKeyWithValue("message", "release 'linkerd' not found"))
+		Expect(out.Components[2]).ToNot(HaveKey("checks"))
+	})
+
+	It("writes an empty list of components as JSON", func() {
+		var b bytes.Buffer
+		Expect(installer.HealthReport(nil).WriteJSON(&b)).To(Succeed())
+		Expect(b.String()).To(MatchJSON(`{"healthy": true, "components": []}`))
+	})
+})
+
+var _ = Describe("Status", func() {
+	var (
+		ctx context.Context
+		cfg *action.Configuration
+		c   installer.Component
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		cfg = fakeHelm()
+
+		// the secret was deleted, checking the component doesn't need it
+		c = installer.Component{
+			ID:           "hello",
+			Type:         installer.Helm,
+			Namespace:    "hello",
+			Source:       installer.Source{Name: "hello", Path: assetPath("charts/hello")},
+			WaitComplete: []installer.ComponentAction{certificateCheck("hello")},
+			Values: installer.Values{{Name: "password", ValueFrom: &installer.ValueSource{
+				SecretKeyRef: &installer.SecretKeyRef{Namespace: "epinio", Name: "deleted", Key: "password"},
+			}}},
+		}
+	})
+
+	// check returns the component's health, the certificate checked by
+	// the component is ready, if ready is "True"
+	check := func(ready string) installer.Health {
+		cluster := fakeCluster(certificate("hello", ready))
+		ca := installer.NewComponentActions(cluster, logr.Discard(), time.Second, nil, nil)
+		s := installer.NewStatus(cluster, logr.Discard(), ca, nil, installer.NewResolver(secrets{}, installer.NewRedactor()))
+		s.SetHelmClient(installer.NewHelmClientForConfig(cfg, nil))
+		return s.Check(ctx, c)
+	}
+
+	// deploy stores a revision of the release with the status
+	deploy := func(status release.Status) {
+		Expect(cfg.Releases.Create(&release.Release{
+			Name:      "hello",
+			Namespace: "hello",
+			Version:   1,
+			Info:      &release.Info{Status: status},
+		})).To(Succeed())
+	}
+
+	It("reports a healthy release", func() {
+		deploy(release.StatusDeployed)
+		h := check("True")
+		Expect(h.Installed).To(BeTrue())
+		Expect(h.Healthy).To(BeTrue())
+		Expect(h.Message).To(BeEmpty())
+		Expect(h.Checks).To(Equal([]installer.CheckHealth{{Check: "Certificate 'hello'", Passed: true}}))
+	})
+
+	It("reports a missing release", func() {
+		h := check("True")
+		Expect(h.Installed).To(BeFalse())
+		Expect(h.Healthy).To(BeFalse())
+		Expect(h.Message).To(Equal("release 'hello' not found"))
+		Expect(h.Checks).To(BeEmpty())
+	})
+
+	It("reports a release, which isn't deployed", func() {
+		deploy(release.StatusFailed)
+		h := check("True")
+		Expect(h.Installed).To(BeTrue())
+		Expect(h.Healthy).To(BeFalse())
+		Expect(h.Message).To(Equal("release 'hello' is failed"))
+	})
+
+	It("reports failing wait complete checks", func() {
+		deploy(release.StatusDeployed)
+		h := check("False")
+		Expect(h.Installed).To(BeTrue())
+		Expect(h.Healthy).To(BeFalse())
+		Expect(h.Checks).To(HaveLen(1))
+		Expect(h.Checks[0].Passed).To(BeFalse())
+		Expect(h.Checks[0].Message).ToNot(BeEmpty())
+	})
+
+	Context("with YAML components", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "epinio-status")
+			Expect(err).ToNot(HaveOccurred())
+
+			obj := certificate("other", "True")
+			b, err := yaml.Marshal(obj.Object)
+			Expect(err).ToNot(HaveOccurred())
+			path := filepath.Join(dir, "certificate.yaml")
+			Expect(ioutil.WriteFile(path, b, 0600)).To(Succeed())
+
+			c.Type = installer.YAML
+			c.Namespace = "epinio"
+			c.Source = installer.Source{Path: path}
+			c.Values = nil
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("reports missing objects", func() {
+			h := check("True")
+			Expect(h.Installed).To(BeFalse())
+			Expect(h.Healthy).To(BeFalse())
+			Expect(h.Message).To(Equal("missing Certificate 'other'"))
+		})
+
+		It("resolves the values to render templates", func() {
+			c.Values = installer.Values{{Name: "password", ValueFrom: &installer.ValueSource{
+				SecretKeyRef: &installer.SecretKeyRef{Namespace: "epinio", Name: "deleted", Key: "password"},
+			}}}
+			h := check("True")
+			Expect(h.Installed).To(BeFalse())
+			Expect(h.Message).To(ContainSubstring("failed to resolve value 'password' of 'hello'"))
+		})
+	})
+})
@@ -13,21 +13,38 @@ type Uninstall struct {
 	cluster *kubernetes.Cluster
 	log     logr.Logger
 	ca      *ComponentActions
+	helm    *HelmClient
+	yaml    *YAMLClient
+	hooks   *HookRunner
 }
 
 var _ Action = &Uninstall{}
 
-func NewUninstall(cluster *kubernetes.Cluster, log logr.Logger, ca *ComponentActions) *Uninstall {
+// NewUninstall returns the uninstall action, the component's files are
+// read from sources
+func NewUninstall(cluster *kubernetes.Cluster, log logr.Logger, ca *ComponentActions, sources *Sources) *Uninstall {
 	return &Uninstall{
 		ca:      ca,
 		cluster: cluster,
 		log:     log,
+		helm:    NewHelmClient(cluster, sources),
+		yaml:    NewYAMLClient(cluster.Dynamic, cluster.Mapper, sources),
+		hooks:   NewHookRunner(cluster.Kubectl, log, ca.timeout, sources),
 	}
 }
 
+// Apply uninstalls the component within the component's timeout. Values
+// with a source are not resolved, helm uninstall and deleting YAML don't
+// use them, so components can be uninstalled after their secrets are gone.
 func (u Uninstall) Apply(ctx context.Context, c Component) error {
+	ctx, cancel := componentContext(ctx, c)
+	defer cancel()
+	return componentError(ctx, c, u.apply(ctx, c))
+}
+
+func (u Uninstall) apply(ctx context.Context, c Component) error {
 	log := u.log.WithValues("component", c.ID, "type", c.Type)
-	log.Info("apply uninstall")
+	log.Info("apply uninstall", "timeout", c.Timeout.String())
 
 	for _, chk := range c.PreDelete {
 		log.V(2).Info("pre deploy", "checkType", string(chk.Type))
@@ -36,17 +53,21 @@ func (u Uninstall) Apply(ctx context.Context, c Component) error {
 		}
 	}
 
+	if err := u.hooks.Run(ctx, c, PreUninstall); err != nil {
+		return err
+	}
+
 	switch c.Type {
 	case Helm:
 		{
-			if err := helmUninstall(log.V(1).WithName("helm"), c); err != nil {
+			if err := u.helm.Uninstall(ctx, log.V(1).WithName("helm"), c); err != nil {
 				return err
 			}
 		}
 
 	case YAML:
 		{
-			if err := yamlDelete(log.V(1).WithName("yaml"), c); err != nil {
+			if err := u.yaml.Delete(ctx, log.V(1).WithName("yaml"), c); err != nil {
 				return err
 			}
 		}
@@ -56,9 +77,8 @@ func (u Uninstall) Apply(ctx context.Context, c Component) error {
 			if err := u.cluster.DeleteNamespace(ctx, c.Namespace); err != nil && !apierrors.IsNotFound(err) {
 				return err
 			}
-			return nil
 		}
 	}
 
-	return nil
+	return u.hooks.Run(ctx, c, PostUninstall)
 }
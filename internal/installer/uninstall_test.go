@@ -0,0 +1,59 @@
+package installer_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	"github.com/epinio/installer/internal/installer"
+)
+
+var _ = Describe("Uninstall", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "epinio-uninstall")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("doesn't resolve values, so secrets may be gone", func() {
+		b, err := yaml.Marshal(certificate("hello", "True").Object)
+		Expect(err).ToNot(HaveOccurred())
+		path := filepath.Join(dir, "certificate.yaml")
+		Expect(ioutil.WriteFile(path, b, 0600)).To(Succeed())
+
+		c := installer.Component{
+			ID:        "hello",
+			Type:      installer.YAML,
+			Namespace: "epinio",
+			Source:    installer.Source{Path: path},
+			Values: installer.Values{{Name: "password", ValueFrom: &installer.ValueSource{
+				SecretKeyRef: &installer.SecretKeyRef{Namespace: "epinio", Name: "deleted", Key: "password"},
+			}}},
+		}
+
+		cluster := fakeCluster(certificate("hello", "True"))
+		ca := installer.NewComponentActions(cluster, logr.Discard(), time.Second, nil, nil)
+		Expect(installer.NewUninstall(cluster, logr.Discard(), ca, nil).Apply(context.TODO(), c)).To(Succeed())
+
+		certificates := schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}
+		_, err = cluster.Dynamic.Resource(certificates).Namespace("epinio").Get(context.TODO(), "hello", metav1.GetOptions{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+})
@@ -0,0 +1,185 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Unchanged components were installed and their definition didn't change
+const Unchanged ChangeType = "unchanged"
+
+// UpgradeItem is the planned change of a component
+type UpgradeItem struct {
+	ID     DeploymentID
+	Change ChangeType
+	Reason string
+}
+
+// UpgradePlan lists the planned changes of the manifest's components,
+// followed by the recorded components, which are no longer in the manifest
+type UpgradePlan []UpgradeItem
+
+// PlanUpgrade compares the components with the recorded state. A
+// component is changed if its source, version or values changed, or if
+// it didn't finish the last time. Changes of e.g. checks or hooks alone
+// don't upgrade a component. The reason names the changed parts of the
+// definition.
+func PlanUpgrade(components Components, state State) UpgradePlan {
+	plan := UpgradePlan{}
+	for _, c := range components {
+		cs, ok := state[c.ID]
+		switch {
+		case !ok:
+			plan = append(plan, UpgradeItem{ID: c.ID, Change: Added, Reason: "not installed"})
+		case cs.Definition == nil && cs.Hash != c.Hash():
+			// states of older versions have no definition
+			plan = append(plan, UpgradeItem{ID: c.ID, Change: Changed, Reason: "definition changed"})
+		case cs.Definition != nil && *cs.Definition != c.Definition():
+			plan = append(plan, UpgradeItem{ID: c.ID, Change: Changed, Reason: changeReason(*cs.Definition, c.Definition())})
+		case cs.Status != StatusDone:
+			plan = append(plan, UpgradeItem{ID: c.ID, Change: Changed, Reason: fmt.Sprintf("last run is %s", cs.Status)})
+		default:
+			plan = append(plan, UpgradeItem{ID: c.ID, Change: Unchanged})
+		}
+	}
+
+	removed := []string{}
+	for id := range state {
+		if !DeploymentIDs(components.IDs()).Contains(id) {
+			removed = append(removed, string(id))
+		}
+	}
+	sort.Strings(removed)
+	for _, id := range removed {
+		plan = append(plan, UpgradeItem{ID: DeploymentID(id), Change: Removed, Reason: "not in the manifest, uninstall it separately"})
+	}
+	return plan
+}
+
+// changeReason describes the changes from the recorded definition
+func changeReason(old, def Definition) string {
+	reasons := []string{}
+	if old.Source != def.Source {
+		reasons = append(reasons, fmt.Sprintf("source %s -> %s", orNone(old.Source), orNone(def.Source)))
+	}
+	if old.Source == def.Source && old.SourceDigest != def.SourceDigest {
+		reasons = append(reasons, "source contents changed")
+	}
+	if old.Version != def.Version {
+		reasons = append(reasons, fmt.Sprintf("version %s -> %s", orNone(old.Version), orNone(def.Version)))
+	}
+	if old.Values != def.Values {
+		reasons = append(reasons, "values changed")
+	}
+	return strings.Join(reasons, ", ")
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return none
+	}
+	return s
+}
+
+// Changed returns the IDs of the components, which will be installed or upgraded
+func (p UpgradePlan) Changed() []DeploymentID {
+	ids := []DeploymentID{}
+	for _, item := range p {
+		if item.Change == Added || item.Change == Changed {
+			ids = append(ids, item.ID)
+		}
+	}
+	return ids
+}
+
+// WritePlan writes what the upgrade will do with each component
+func (p UpgradePlan) WritePlan(w io.Writer) {
+	verbs := map[ChangeType]string{Added: "install", Changed: "upgrade", Unchanged: "keep", Removed: "ignore"}
+	for _, item := range p {
+		writeItem(w, verbs[item.Change], item)
+	}
+}
+
+// WriteSummary writes what the upgrade did with each component, err is
+// the walk's error
+func (p UpgradePlan) WriteSummary(w io.Writer, err error) {
+	failed := map[DeploymentID]error{}
+	skipped := map[DeploymentID]bool{}
+	if werr := (&WalkError{}); errors.As(err, &werr) {
+		for _, f := range werr.Failed {
+			failed[f.ID] = f.Err
+		}
+		for _, id := range werr.Skipped {
+			skipped[id] = true
+		}
+	}
+
+	verbs := map[ChangeType]string{Added: "installed", Changed: "upgraded", Unchanged: "unchanged", Removed: "ignored"}
+	for _, item := range p {
+		switch {
+		case failed[item.ID] != nil:
+			fmt.Fprintf(w, "failed '%s': %v\n", item.ID, failed[item.ID])
+		case skipped[item.ID] && item.Change != Unchanged:
+			writeItem(w, "skipped", item)
+		default:
+			writeItem(w, verbs[item.Change], item)
+		}
+	}
+}
+
+func writeItem(w io.Writer, verb string, item UpgradeItem) {
+	if item.Reason == "" {
+		fmt.Fprintf(w, "%s '%s'\n", verb, item.ID)
+		return
+	}
+	fmt.Fprintf(w, "%s '%s': %s\n", verb, item.ID, item.Reason)
+}
+
+// Upgrade is the install action, which also runs the pre and post upgrade
+// checks of components, which were installed before
+type Upgrade struct {
+	Install
+	installed State
+}
+
+var _ Action = &Upgrade{}
+
+// NewUpgrade wraps the install action, installed is the state recorded
+// before the upgrade started
+func NewUpgrade(install *Install, installed State) *Upgrade {
+	return &Upgrade{
+		Install:   *install,
+		installed: installed,
+	}
+}
+
+// Apply resolves the component's values, before upgrading it within the
+// component's timeout. Resolved secrets are redacted from the returned error.
+func (u Upgrade) Apply(ctx context.Context, c Component) error {
+	return u.run(ctx, c, u.apply)
+}
+
+func (u Upgrade) apply(ctx context.Context, c Component) error {
+	// pending components were never started, so they're not installed
+	cs, ok := u.installed[c.ID]
+	upgrading := ok && cs.Status != StatusPending
+	if !upgrading {
+		return u.Install.apply(ctx, c, nil)
+	}
+
+	log := u.log.WithValues("component", c.ID, "type", c.Type)
+	for _, chk := range c.PreUpgrade {
+		log.V(2).Info("pre upgrade", "checkType", string(chk.Type))
+		if err := u.ca.Run(ctx, c, chk); err != nil {
+			return err
+		}
+	}
+
+	// a failed post upgrade check rolls back, like a failed wait complete check
+	return u.Install.apply(ctx, c, c.PostUpgrade)
+}
@@ -0,0 +1,341 @@
+package installer_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/go-logr/logr"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chartutil"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+
+	"github.com/epinio/installer/internal/installer"
+	"github.com/epinio/installer/internal/kubernetes"
+)
+
+// fakeCluster returns a cluster whose dynamic client is a fake serving
+// the objects. Its clientset doesn't reach any server.
+func fakeCluster(objs ...runtime.Object) *kubernetes.Cluster {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}, meta.RESTScopeNamespace)
+
+	certificates := schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		certificates: "CertificateList",
+	}, objs...)
+
+	config := &rest.Config{Host: "http://127.0.0.1:1"}
+	kubectl, err := clientset.NewForConfig(config)
+	Expect(err).ToNot(HaveOccurred())
+
+	return &kubernetes.Cluster{Kubectl: kubectl, Dynamic: dyn, Mapper: mapper, RestConfig: config}
+}
+
+// fakeHelm returns a helm configuration, which stores releases in memory
+func fakeHelm() *action.Configuration {
+	return &action.Configuration{
+		Releases:     storage.Init(driver.NewMemory()),
+		KubeClient:   &kubefake.PrintingKubeClient{Out: ioutil.Discard},
+		Capabilities: chartutil.DefaultCapabilities,
+	}
+}
+
+// certificateCheck waits for the certificate to be ready
+func certificateCheck(name string) installer.ComponentAction {
+	return installer.ComponentAction{
+		Type:       installer.Condition,
+		APIVersion: "cert-manager.io/v1",
+		Kind:       "Certificate",
+		Namespace:  "epinio",
+		Name:       name,
+		Condition:  "Ready",
+		Timeout:    installer.Duration(100 * time.Millisecond),
+		Interval:   installer.Duration(10 * time.Millisecond),
+	}
+}
+
+// startedChecks returns the subjects of the started checks in the events
+func startedChecks(b *bytes.Buffer) []string {
+	var checks []string
+	dec := json.NewDecoder(b)
+	for dec.More() {
+		var ev installer.Event
+		Expect(dec.Decode(&ev)).To(Succeed())
+		if ev.Type == installer.EventCheckStarted {
+			checks = append(checks, ev.Check)
+		}
+	}
+	return checks
+}
+
+var _ = Describe("PlanUpgrade", func() {
+	var cs installer.Components
+	var state installer.State
+
+	BeforeEach(func() {
+		cs = installer.Components{
+			{ID: "epinio-namespace", Type: installer.Namespace, Namespace: "epinio"},
+			{ID: "cert-manager", Type: installer.Helm, Source: installer.Source{Version: "v1.8.0"}},
+			{ID: "linkerd", Type: installer.Helm},
+			{ID: "tekton", Type: installer.YAML},
+		}
+
+		old := cs[1]
+		old.Source.Version = "v1.7.0"
+		def := old.Definition()
+		state = installer.State{
+			"epinio-namespace": {Status: installer.StatusDone, Hash: cs[0].Hash()},
+			"cert-manager":     {Status: installer.StatusDone, Hash: old.Hash(), Definition: &def},
+			"tekton":           {Status: installer.StatusFailed, Hash: cs[3].Hash()},
+			"traefik":          {Status: installer.StatusDone},
+		}
+	})
+
+	It("compares the components with the recorded state", func() {
+		plan := installer.PlanUpgrade(cs, state)
+		Expect(plan).To(Equal(installer.UpgradePlan{
+			{ID: "epinio-namespace", Change: installer.Unchanged},
+			{ID: "cert-manager", Change: installer.Changed, Reason: "version v1.7.0 -> v1.8.0"},
+			{ID: "linkerd", Change: installer.Added, Reason: "not installed"},
+			{ID: "tekton", Change: installer.Changed, Reason: "last run is failed"},
+			{ID: "traefik", Change: installer.Removed, Reason: "not in the manifest, uninstall it separately"},
+		}))
+		Expect(plan.Changed()).To(Equal([]installer.DeploymentID{"cert-manager", "linkerd", "tekton"}))
+	})
+
+	It("names the changed parts of the definition", func() {
+		reason := func(old, c installer.Component) string {
+			def := old.Definition()
+			state := installer.State{c.ID: {Status: installer.StatusDone, Hash: old.Hash(), Definition: &def}}
+			return installer.PlanUpgrade(installer.Components{c}, state)[0].Reason
+		}
+
+		old := installer.Component{ID: "hello", Type: installer.Helm, Source: installer.Source{Chart: "hello", URL: "https://charts.example.com", Version: "0.1.0"}}
+		c := old
+		c.Source.Chart = "oci://ghcr.io/example/hello"
+		c.Source.URL = ""
+		c.Values = installer.Values{{Name: "replicas", Value: "2"}}
+		Expect(reason(old, c)).To(Equal("source https://charts.example.com hello -> oci://ghcr.io/example/hello, values changed"))
+
+		c = old
+		c.Source.Version = ""
+		c.Source.Digest = "sha256:abc"
+		Expect(reason(old, c)).To(Equal("version 0.1.0 -> sha256:abc"))
+
+		// states of older versions have no definition
+		c = old
+		c.WaitComplete = []installer.ComponentAction{{Type: installer.Pod, Selector: "app=hello"}}
+		state := installer.State{"hello": {Status: installer.StatusDone, Hash: old.Hash()}}
+		Expect(installer.PlanUpgrade(installer.Components{c}, state)[0].Reason).To(Equal("definition changed"))
+	})
+
+	It("doesn't upgrade components, whose checks or hooks changed", func() {
+		old := installer.Component{ID: "hello", Type: installer.Helm, Source: installer.Source{Chart: "hello", Version: "0.1.0"}}
+		def := old.Definition()
+		state := installer.State{"hello": {Status: installer.StatusDone, Hash: old.Hash(), Definition: &def}}
+
+		c := old
+		c.Timeout = installer.Duration(time.Minute)
+		c.WaitComplete = []installer.ComponentAction{{Type: installer.Pod, Selector: "app=hello", Timeout: installer.Duration(time.Second)}}
+		c.Hooks = &installer.Hooks{PostInstall: []installer.Hook{{Command: []string{"true"}}}}
+		Expect(installer.PlanUpgrade(installer.Components{c}, state)).To(Equal(installer.UpgradePlan{
+			{ID: "hello", Change: installer.Unchanged},
+		}))
+	})
+
+	It("writes the plan", func() {
+		var b bytes.Buffer
+		installer.PlanUpgrade(cs, state).WritePlan(&b)
+		Expect(b.String()).To(Equal(`keep 'epinio-namespace'
+upgrade 'cert-manager': version v1.7.0 -> v1.8.0
+install 'linkerd': not installed
+upgrade 'tekton': last run is failed
+ignore 'traefik': not in the manifest, uninstall it separately
+`))
+	})
+
+	It("writes a summary of the upgrade", func() {
+		werr := &installer.WalkError{
+			Failed:  []installer.ComponentError{{ID: "linkerd", Err: errors.New("timed out")}},
+			Skipped: []installer.DeploymentID{"epinio-namespace", "tekton"},
+		}
+
+		var b bytes.Buffer
+		installer.PlanUpgrade(cs, state).WriteSummary(&b, werr)
+		Expect(b.String()).To(Equal(`unchanged 'epinio-namespace'
+upgraded 'cert-manager': version v1.7.0 -> v1.8.0
+failed 'linkerd': timed out
+skipped 'tekton': last run is failed
+ignored 'traefik': not in the manifest, uninstall it separately
+`))
+	})
+})
+
+var _ = Describe("Upgrade", func() {
+	var (
+		ctx     context.Context
+		cfg     *action.Configuration
+		store   *installer.StateStore
+		events  bytes.Buffer
+		cs      installer.Components
+		install func(*kubernetes.Cluster) *installer.Install
+	)
+
+	hello := func(id installer.DeploymentID) installer.Component {
+		return installer.Component{
+			ID:          id,
+			Type:        installer.Helm,
+			Namespace:   "hello",
+			Source:      installer.Source{Name: string(id), Path: assetPath("charts/hello")},
+			PreUpgrade:  []installer.ComponentAction{certificateCheck("pre-" + string(id))},
+			PostUpgrade: []installer.ComponentAction{certificateCheck("post-" + string(id))},
+		}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		cfg = fakeHelm()
+		store = installer.NewStateStore(fake.NewSimpleClientset(), "epinio-installer")
+		events.Reset()
+
+		install = func(cluster *kubernetes.Cluster) *installer.Install {
+			ca := installer.NewComponentActions(cluster, logr.Discard(), time.Second, nil, installer.NewEvents(&events, installer.NewRedactor()))
+			i := installer.NewInstall(cluster, logr.Discard(), ca, nil, installer.NewResolver(nil, installer.NewRedactor()), store, false)
+			i.SetHelmClient(installer.NewHelmClientForConfig(cfg, nil))
+			return i
+		}
+
+		cs = installer.Components{hello("upgraded"), hello("added"), hello("unchanged")}
+		Expect(cs.ReadContents(ctx, nil)).To(Succeed())
+		old := cs[0]
+		old.Source.Version = "0.0.1"
+		Expect(store.Update(ctx, "upgraded", func(s *installer.ComponentState) {
+			*s = installer.ComponentState{Status: installer.StatusDone, Hash: old.Hash()}
+		})).To(Succeed())
+		Expect(store.Update(ctx, "unchanged", func(s *installer.ComponentState) {
+			*s = installer.ComponentState{Status: installer.StatusDone, Hash: cs[2].Hash()}
+		})).To(Succeed())
+	})
+
+	// upgrade runs the upgrade like the CLI, skipping unchanged components
+	upgrade := func(cluster *kubernetes.Cluster) error {
+		Expect(cs.ReadContents(ctx, nil)).To(Succeed())
+		state, err := store.Load(ctx)
+		Expect(err).ToNot(HaveOccurred())
+
+		stateful := installer.NewStateful(installer.NewUpgrade(install(cluster), state), store, logr.Discard(), false)
+		Expect(stateful.PrepareUpgrade(ctx, cs, installer.PlanUpgrade(cs, state))).To(Succeed())
+		return installer.Walk(ctx, cs, stateful)
+	}
+
+	It("runs the upgrade checks only for installed components", func() {
+		cluster := fakeCluster(certificate("pre-upgraded", "True"), certificate("post-upgraded", "True"))
+		Expect(upgrade(cluster)).To(Succeed())
+
+		Expect(startedChecks(&events)).To(Equal([]string{"Certificate 'pre-upgraded'", "Certificate 'post-upgraded'"}))
+
+		for _, id := range []string{"upgraded", "added"} {
+			_, err := cfg.Releases.Last(id)
+			Expect(err).ToNot(HaveOccurred(), id)
+		}
+	})
+
+	It("skips unchanged components", func() {
+		cluster := fakeCluster(certificate("pre-upgraded", "True"), certificate("post-upgraded", "True"))
+		Expect(upgrade(cluster)).To(Succeed())
+
+		_, err := cfg.Releases.Last("unchanged")
+		Expect(err).To(MatchError(driver.ErrReleaseNotFound))
+
+		state, err := store.Load(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(state["unchanged"].Status).To(Equal(installer.StatusDone))
+		Expect(state["unchanged"].Started).To(BeNil())
+	})
+
+	It("skips components, whose checks changed", func() {
+		def := cs[2].Definition()
+		Expect(store.Update(ctx, "unchanged", func(s *installer.ComponentState) {
+			*s = installer.ComponentState{Status: installer.StatusDone, Hash: cs[2].Hash(), Definition: &def}
+		})).To(Succeed())
+		cs[2].PostUpgrade[0].Timeout = installer.Duration(time.Second)
+
+		cluster := fakeCluster(certificate("pre-upgraded", "True"), certificate("post-upgraded", "True"))
+		Expect(upgrade(cluster)).To(Succeed())
+
+		_, err := cfg.Releases.Last("unchanged")
+		Expect(err).To(MatchError(driver.ErrReleaseNotFound))
+	})
+
+	It("upgrades components, whose values files changed", func() {
+		dir, err := ioutil.TempDir("", "epinio-upgrade")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		values := filepath.Join(dir, "values.yaml")
+		Expect(ioutil.WriteFile(values, []byte("greeting: hi\n"), 0600)).To(Succeed())
+		cs[2].ValuesFiles = []string{values}
+		Expect(cs.ReadContents(ctx, nil)).To(Succeed())
+		def := cs[2].Definition()
+		Expect(store.Update(ctx, "unchanged", func(s *installer.ComponentState) {
+			*s = installer.ComponentState{Status: installer.StatusDone, Hash: cs[2].Hash(), Definition: &def}
+		})).To(Succeed())
+
+		Expect(ioutil.WriteFile(values, []byte("greeting: hey\n"), 0600)).To(Succeed())
+		Expect(cs.ReadContents(ctx, nil)).To(Succeed())
+		state, err := store.Load(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(installer.PlanUpgrade(cs, state)).To(ContainElement(installer.UpgradeItem{ID: "unchanged", Change: installer.Changed, Reason: "values changed"}))
+
+		cluster := fakeCluster(certificate("pre-upgraded", "True"), certificate("post-upgraded", "True"),
+			certificate("pre-unchanged", "True"), certificate("post-unchanged", "True"))
+		Expect(upgrade(cluster)).To(Succeed())
+
+		rel, err := cfg.Releases.Last("unchanged")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rel.Config["greeting"]).To(Equal("hey"))
+	})
+
+	It("rolls back a component, if a post upgrade check fails", func() {
+		Expect(installer.NewHelmClientForConfig(cfg, nil).Update(ctx, logr.Discard(), cs[0])).To(Succeed())
+		cs[0].RollbackOnFailure = true
+		cs[0].Values = installer.Values{{Name: "greeting", Value: "hi"}}
+
+		cluster := fakeCluster(certificate("pre-upgraded", "True"), certificate("post-upgraded", "False"))
+		err := upgrade(cluster)
+		Expect(err).To(MatchError(ContainSubstring("rolled back release 'upgraded' to revision 1")))
+
+		rel, err := cfg.Releases.Last("upgraded")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rel.Version).To(Equal(3))
+		Expect(rel.Config["greeting"]).To(BeNil())
+	})
+
+	It("doesn't upgrade a component, if a pre upgrade check fails", func() {
+		cluster := fakeCluster(certificate("pre-upgraded", "False"))
+		err := upgrade(cluster)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("upgraded"))
+
+		_, err = cfg.Releases.Last("upgraded")
+		Expect(err).To(MatchError(driver.ErrReleaseNotFound))
+	})
+})
@@ -0,0 +1,445 @@
+package installer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// Problem describes a single issue found in a manifest
+type Problem struct {
+	// Line in the manifest file, 0 if unknown
+	Line int `json:"line"`
+
+	// Component the problem was found in, empty for manifest-wide problems
+	Component DeploymentID `json:"component,omitempty"`
+
+	// Field is the path to the offending field, e.g. 'waitComplete[1].type'
+	Field string `json:"field,omitempty"`
+
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	var b strings.Builder
+	if p.Line > 0 {
+		fmt.Fprintf(&b, "line %d: ", p.Line)
+	}
+	if p.Component != "" {
+		fmt.Fprintf(&b, "component '%s': ", p.Component)
+	}
+	if p.Field != "" {
+		fmt.Fprintf(&b, "%s: ", p.Field)
+	}
+	b.WriteString(p.Message)
+	return b.String()
+}
+
+type Problems []Problem
+
+func (ps Problems) String() string {
+	lines := make([]string, 0, len(ps))
+	for _, p := range ps {
+		lines = append(lines, p.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+var (
+	knownComponentTypes = map[ComponentType]bool{YAML: true, Helm: true, Namespace: true}
+	knownActionTypes    = map[ActionType]bool{
+		Job: true, Pod: true, Loadbalancer: true, CRD: true,
+		Deployment: true, StatefulSet: true, DaemonSet: true, Secret: true, NamespaceCheck: true, Condition: true,
+	}
+	knownValueTypes = map[ValueType]bool{"": true, Label: true, Annotation: true}
+)
+
+// Validate loads the manifest at path and checks it for problems, which
+// would only surface when installing. It does not need a cluster.
+// An error is returned if the file can't be read or parsed at all.
+func Validate(path string) (Problems, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	if err := yaml.Unmarshal(b, m); err != nil {
+		return nil, err
+	}
+
+	doc := &yamlv3.Node{}
+	if err := yamlv3.Unmarshal(b, doc); err != nil {
+		return nil, err
+	}
+
+	problems := Problems{}
+
+	// strict unmarshalling finds unknown and duplicate keys
+	if err := yaml.UnmarshalStrict(b, &Manifest{}); err != nil {
+		terr, ok := err.(*yaml.TypeError)
+		if !ok {
+			return nil, err
+		}
+		for _, msg := range terr.Errors {
+			problems = append(problems, strictProblem(msg))
+		}
+	}
+
+	return append(problems, validate(m, doc)...), nil
+}
+
+var lineRegex = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+var sha256Regexp = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// strictProblem converts a yaml.v2 type error message, like "line 3: field foo not found in type installer.Source"
+func strictProblem(msg string) Problem {
+	match := lineRegex.FindStringSubmatch(msg)
+	if match == nil {
+		return Problem{Message: msg}
+	}
+	line, _ := strconv.Atoi(match[1])
+	return Problem{Line: line, Message: match[2]}
+}
+
+// validate checks the manifest, doc is used to find line numbers
+func validate(m *Manifest, doc *yamlv3.Node) Problems {
+	v := &validator{doc: doc, comps: m.Components}
+
+	if len(m.Components) == 0 {
+		v.add(-1, "", "no components defined", "components")
+		return v.problems
+	}
+
+	// the graph is only checked for cycles if all ids are unique and
+	// all edges point somewhere
+	graphOK := true
+
+	ids := map[DeploymentID]int{}
+	for i, c := range m.Components {
+		if c.ID == "" {
+			v.add(i, "id", "missing id")
+			graphOK = false
+			continue
+		}
+		if first, ok := ids[c.ID]; ok {
+			v.add(i, "id", fmt.Sprintf("duplicate id, first defined at line %d", v.line(first)))
+			graphOK = false
+			continue
+		}
+		ids[c.ID] = i
+	}
+
+	for i, c := range m.Components {
+		for j, n := range c.Needs {
+			field := fmt.Sprintf("needs[%d]", j)
+			if n == c.ID {
+				v.add(i, field, "component needs itself", "needs", j)
+				graphOK = false
+			} else if _, ok := ids[n]; !ok {
+				v.add(i, field, fmt.Sprintf("unknown component '%s'", n), "needs", j)
+				graphOK = false
+			}
+		}
+
+		v.component(i, c)
+	}
+
+	if graphOK {
+		if _, err := BuildPlan(m.Components); err != nil {
+			v.add(-1, "", err.Error(), "components")
+		}
+	}
+
+	return v.problems
+}
+
+type validator struct {
+	doc      *yamlv3.Node
+	problems Problems
+	comps    Components
+}
+
+func (v *validator) component(i int, c Component) {
+	if !knownComponentTypes[c.Type] {
+		v.add(i, "type", fmt.Sprintf("unknown component type '%s'", c.Type), "type")
+	}
+
+	switch c.Type {
+	case Helm:
+		if c.Source.Name == "" {
+			v.add(i, "source.name", "helm release name missing", "source")
+		}
+		if !c.Source.IsPath() && !c.Source.IsURL() && !c.Source.IsHelmRef() && !c.Source.IsOCI() && !c.Source.IsGit() {
+			v.add(i, "source", "helm source needs either a path, a url, a chart with a repo url, an oci:// chart or a git repository", "source")
+		}
+		if c.Source.IsOCI() {
+			v.oci(i, c.Source)
+		}
+		for j, f := range c.ValuesFiles {
+			if f == "" {
+				v.add(i, fmt.Sprintf("valuesFiles[%d]", j), "empty values file path", "valuesFiles", j)
+			}
+		}
+	case YAML:
+		if c.Source.Path == "" && c.Source.Git == nil && c.Source.URL == "" {
+			v.add(i, "source.path", "empty path for YAML component", "source")
+		}
+		if c.Source.URL != "" {
+			if c.Source.Path != "" || c.Source.Git != nil {
+				v.add(i, "source.url", "url is exclusive with path and git", "source", "url")
+			}
+			if !isHTTPURL(c.Source.URL) {
+				v.add(i, "source.url", "YAML url needs http or https", "source", "url")
+			}
+		}
+		if c.Source.Path != "" && c.Source.Git != nil {
+			v.add(i, "source.git", "path and git are exclusive", "source", "git")
+		}
+		if g := c.Source.Git; g != nil && g.Path == "" {
+			v.add(i, "source.git.path", "git source needs the path of the YAML file", "source", "git")
+		}
+	case Namespace:
+		if c.Namespace == "" {
+			v.add(i, "namespace", "namespace component without namespace")
+		}
+	}
+
+	if g := c.Source.Git; g != nil && g.URL == "" {
+		v.add(i, "source.git.url", "git source needs a url", "source", "git")
+	}
+
+	if sum := c.Source.SHA256; sum != "" {
+		if !sha256Regexp.MatchString(sum) {
+			v.add(i, "source.sha256", fmt.Sprintf("invalid sha256 '%s', expected 64 hex characters", sum), "source", "sha256")
+		}
+		if c.Source.IsOCI() {
+			v.add(i, "source.sha256", "oci:// charts are pinned by digest, not sha256", "source", "sha256")
+		}
+	}
+
+	if c.Source.Registry != nil && !c.Source.IsOCI() {
+		v.add(i, "source.registry", "registry is only supported by oci:// charts", "source", "registry")
+	}
+
+	if c.Type != Helm {
+		if len(c.ValuesFiles) > 0 {
+			v.add(i, "valuesFiles", "values files are only supported by helm components")
+		}
+		if len(c.ValuesObject) > 0 {
+			v.add(i, "valuesObject", "values object is only supported by helm components")
+		}
+	}
+
+	if c.Source.Template && c.Type != YAML {
+		v.add(i, "source.template", "template is only supported by YAML components", "source", "template")
+	}
+
+	for j, val := range c.Values {
+		if !knownValueTypes[val.Type] {
+			v.add(i, fmt.Sprintf("values[%d].type", j), fmt.Sprintf("unknown value type '%s'", val.Type), "values", j, "type")
+		}
+		if val.Name == "" {
+			v.add(i, fmt.Sprintf("values[%d].name", j), "value without name", "values", j)
+		}
+		if vs := val.ValueFrom; vs != nil {
+			v.valueSource(i, j, val, *vs)
+		}
+	}
+
+	if c.Timeout < 0 {
+		v.add(i, "timeout", "timeout must not be negative")
+	}
+
+	if c.RollbackOnFailure && c.Type != Helm {
+		v.add(i, "rollbackOnFailure", "rollbackOnFailure is only supported by helm components", "rollbackOnFailure")
+	}
+
+	v.actions(i, "preDeploy", c.PreDeploy)
+	v.actions(i, "waitComplete", c.WaitComplete)
+	v.actions(i, "preUpgrade", c.PreUpgrade)
+	v.actions(i, "postUpgrade", c.PostUpgrade)
+	v.actions(i, "preDelete", c.PreDelete)
+
+	if c.Hooks != nil {
+		for _, phase := range []HookPhase{PreInstall, PostInstall, PreUninstall, PostUninstall} {
+			v.hooks(i, phase, c.hooks(phase))
+		}
+	}
+}
+
+func (v *validator) oci(i int, s Source) {
+	if s.Version == "" && s.Digest == "" {
+		v.add(i, "source", "oci:// chart needs a version or a digest", "source")
+	}
+	if s.Digest != "" {
+		if _, err := digest.Parse(s.Digest); err != nil {
+			v.add(i, "source.digest", fmt.Sprintf("invalid digest '%s': %v", s.Digest, err), "source", "digest")
+		}
+	}
+}
+
+func (v *validator) hooks(i int, phase HookPhase, hooks []Hook) {
+	for j, h := range hooks {
+		field := fmt.Sprintf("hooks.%s[%d]", phase, j)
+		if (len(h.Command) == 0) == (h.Job == "") {
+			v.add(i, field, "hook needs either a command or a job", "hooks", string(phase), j)
+		}
+		if h.Timeout < 0 {
+			v.add(i, field, "timeout must not be negative", "hooks", string(phase), j)
+		}
+	}
+}
+
+func (v *validator) valueSource(i int, j int, val Value, vs ValueSource) {
+	field := fmt.Sprintf("values[%d].valueFrom", j)
+	if val.Value != "" {
+		v.add(i, field, "value and valueFrom are exclusive", "values", j, "valueFrom")
+	}
+
+	n := 0
+	for _, set := range []bool{vs.SecretKeyRef != nil, vs.Env != "", vs.File != ""} {
+		if set {
+			n++
+		}
+	}
+	if n != 1 {
+		v.add(i, field, "value source needs exactly one of secretKeyRef, env or file", "values", j, "valueFrom")
+	}
+
+	if ref := vs.SecretKeyRef; ref != nil && (ref.Name == "" || ref.Key == "") {
+		v.add(i, field+".secretKeyRef", "secret key ref needs a name and a key", "values", j, "valueFrom", "secretKeyRef")
+	}
+}
+
+func (v *validator) actions(i int, key string, actions []ComponentAction) {
+	for j, a := range actions {
+		if !knownActionTypes[a.Type] {
+			v.add(i, fmt.Sprintf("%s[%d].type", key, j), fmt.Sprintf("unknown check type '%s'", a.Type), key, j, "type")
+			continue
+		}
+		if a.Timeout < 0 || a.Interval < 0 || a.Retries < 0 {
+			v.add(i, fmt.Sprintf("%s[%d]", key, j), "timeout, interval and retries must not be negative", key, j)
+		}
+		if a.Type == Condition {
+			v.condition(i, fmt.Sprintf("%s[%d]", key, j), a, key, j)
+			continue
+		}
+		if a.Selector == "" {
+			v.add(i, fmt.Sprintf("%s[%d].selector", key, j), "check without selector", key, j)
+		}
+	}
+}
+
+// condition checks the fields of a 'condition' check
+func (v *validator) condition(i int, field string, a ComponentAction, path ...interface{}) {
+	if a.APIVersion == "" || a.Kind == "" {
+		v.add(i, field, "condition check needs apiVersion and kind", path...)
+	}
+	if (a.Name == "") == (a.Selector == "") {
+		v.add(i, field, "condition check needs either a name or a selector", path...)
+	}
+	if (a.Condition == "") == (a.JSONPath == "") {
+		v.add(i, field, "condition check needs either a condition or a jsonPath", path...)
+	}
+	if a.JSONPath != "" {
+		if _, err := parseJSONPath(a.JSONPath); err != nil {
+			v.add(i, field+".jsonPath", err.Error(), append(path, "jsonPath")...)
+		}
+	}
+}
+
+// add records a problem for component i, path is used to look up the
+// line number, it defaults to the field name
+func (v *validator) add(i int, field string, msg string, path ...interface{}) {
+	if path == nil {
+		path = []interface{}{field}
+	}
+
+	p := Problem{Field: field, Message: msg}
+	if i >= 0 {
+		p.Line = v.line(i, path...)
+		p.Component = v.comps[i].ID
+	} else {
+		p.Line = lineOf(v.root(), path...)
+	}
+	v.problems = append(v.problems, p)
+}
+
+// line returns the line of the field at path in component i, or the
+// component's line if the field is missing
+func (v *validator) line(i int, path ...interface{}) int {
+	return lineOf(v.componentNode(i), path...)
+}
+
+func (v *validator) root() *yamlv3.Node {
+	if v.doc == nil || len(v.doc.Content) == 0 {
+		return nil
+	}
+	return v.doc.Content[0]
+}
+
+func (v *validator) componentNode(i int) *yamlv3.Node {
+	cs := valueOf(v.root(), "components")
+	if cs == nil || cs.Kind != yamlv3.SequenceNode || i >= len(cs.Content) {
+		return nil
+	}
+	return cs.Content[i]
+}
+
+// lineOf walks the path of mapping keys (string) and sequence indexes
+// (int), it returns the line of the deepest key or item found
+func lineOf(n *yamlv3.Node, path ...interface{}) int {
+	if n == nil {
+		return 0
+	}
+
+	line := n.Line
+	for _, p := range path {
+		switch k := p.(type) {
+		case string:
+			var key *yamlv3.Node
+			key, n = keyValueOf(n, k)
+			if key != nil {
+				line = key.Line
+			}
+		case int:
+			if n.Kind != yamlv3.SequenceNode || k >= len(n.Content) {
+				n = nil
+			} else {
+				n = n.Content[k]
+				line = n.Line
+			}
+		}
+		if n == nil {
+			break
+		}
+	}
+	return line
+}
+
+// valueOf returns the value node for key in a mapping node
+func valueOf(n *yamlv3.Node, key string) *yamlv3.Node {
+	_, v := keyValueOf(n, key)
+	return v
+}
+
+// keyValueOf returns the key and value nodes for key in a mapping node
+func keyValueOf(n *yamlv3.Node, key string) (*yamlv3.Node, *yamlv3.Node) {
+	if n == nil || n.Kind != yamlv3.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i], n.Content[i+1]
+		}
+	}
+	return nil, nil
+}
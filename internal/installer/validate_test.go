@@ -0,0 +1,67 @@
+package installer_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+
+	"github.com/epinio/installer/internal/installer"
+)
+
+var _ = Describe("Validate", func() {
+	It("finds no problems in a valid manifest", func() {
+		problems, err := installer.Validate(assetPath("test-manifest.yml"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(problems).To(BeEmpty())
+	})
+
+	It("returns an error if the manifest can't be read", func() {
+		_, err := installer.Validate(assetPath("does-not-exist.yml"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("lists all problems with line numbers", func() {
+		problems, err := installer.Validate(assetPath("invalid-manifest.yml"))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(problems).To(ContainElements(
+			installer.Problem{Line: 23, Message: "field unknownKey not found in type installer.Component"},
+			installer.Problem{Line: 5, Component: "linkerd", Field: "source.url", Message: "YAML url needs http or https"},
+			installer.Problem{Line: 11, Component: "traefik", Field: "source", Message: "helm source needs either a path, a url, a chart with a repo url, an oci:// chart or a git repository"},
+			installer.Problem{Line: 15, Component: "traefik", Field: "waitComplete[0].type", Message: "unknown check type 'service'"},
+			installer.Problem{Line: 18, Component: "traefik", Field: "id", Message: "duplicate id, first defined at line 7"},
+			installer.Problem{Line: 21, Component: "traefik", Field: "needs[1]", Message: "unknown component 'missing'"},
+			installer.Problem{Line: 22, Component: "traefik", Field: "type", Message: "unknown component type 'chart'"},
+			installer.Problem{Line: 28, Component: "epinio-namespace", Field: "valuesObject", Message: "values object is only supported by helm components"},
+			installer.Problem{Line: 33, Component: "epinio-namespace", Field: "values[0].valueFrom", Message: "value and valueFrom are exclusive"},
+			installer.Problem{Line: 33, Component: "epinio-namespace", Field: "values[0].valueFrom", Message: "value source needs exactly one of secretKeyRef, env or file"},
+			installer.Problem{Line: 35, Component: "epinio-namespace", Field: "values[0].valueFrom.secretKeyRef", Message: "secret key ref needs a name and a key"},
+			installer.Problem{Line: 38, Component: "epinio-namespace", Field: "waitComplete[0]", Message: "condition check needs apiVersion and kind"},
+			installer.Problem{Line: 38, Component: "epinio-namespace", Field: "waitComplete[0]", Message: "condition check needs either a name or a selector"},
+			installer.Problem{Line: 41, Component: "epinio-namespace", Field: "rollbackOnFailure", Message: "rollbackOnFailure is only supported by helm components"},
+			installer.Problem{Line: 44, Component: "epinio-namespace", Field: "hooks.preInstall[0]", Message: "hook needs either a command or a job"},
+			installer.Problem{Line: 50, Component: "oci-unpinned", Field: "source", Message: "oci:// chart needs a version or a digest"},
+			installer.Problem{Line: 60, Component: "oci-digest", Field: "source.digest", Message: "invalid digest 'sha256:1234': invalid checksum digest length"},
+			installer.Problem{Line: 67, Component: "yaml-registry", Field: "source.registry", Message: "registry is only supported by oci:// charts"},
+			installer.Problem{Line: 74, Component: "yaml-git", Field: "source.git", Message: "path and git are exclusive"},
+			installer.Problem{Line: 74, Component: "yaml-git", Field: "source.git.path", Message: "git source needs the path of the YAML file"},
+			installer.Problem{Line: 74, Component: "yaml-git", Field: "source.git.url", Message: "git source needs a url"},
+			installer.Problem{Line: 81, Component: "yaml-sha256", Field: "source.sha256", Message: "invalid sha256 'abc', expected 64 hex characters"},
+			installer.Problem{Line: 83, Component: "yaml-empty", Field: "source.path", Message: "empty path for YAML component"},
+			installer.Problem{Line: 92, Component: "helm-template", Field: "source.template", Message: "template is only supported by YAML components"},
+		))
+		Expect(problems).To(ContainElement(MatchFields(IgnoreExtras, Fields{
+			"Line":    Equal(40),
+			"Field":   Equal("waitComplete[0].jsonPath"),
+			"Message": HavePrefix("invalid JSONPath '{.status'"),
+		})))
+		Expect(problems).To(HaveLen(25))
+	})
+
+	It("finds cycles", func() {
+		problems, err := installer.Validate(assetPath("cycle-manifest.yml"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(problems).To(HaveLen(1))
+		Expect(problems[0].Message).To(ContainSubstring("cycle"))
+	})
+})
@@ -0,0 +1,320 @@
+package installer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// EnvVarPrefix is the prefix of environment variables, which set manifest variables
+const EnvVarPrefix = "EPINIO_VAR_"
+
+// Variables are available as '.Vars' to templates in the manifest's
+// fields and in the sources of YAML components
+type Variables map[string]string
+
+// Merge returns a copy of vars, overridden by all others in order
+func (vars Variables) Merge(others ...Variables) Variables {
+	merged := Variables{}
+	for k, v := range vars {
+		merged[k] = v
+	}
+	for _, o := range others {
+		for k, v := range o {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// LoadVariables reads a YAML file with a map of variables
+func LoadVariables(path string) (Variables, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := Variables{}
+	if err := yaml.Unmarshal(b, &vars); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse variables file '%s'", path)
+	}
+	return vars, nil
+}
+
+// VariablesFromEnv returns the variables set by environment variables
+// with the EPINIO_VAR_ prefix, e.g. EPINIO_VAR_loadbalancerIP
+func VariablesFromEnv(environ []string) Variables {
+	vars := Variables{}
+	for _, e := range environ {
+		if !strings.HasPrefix(e, EnvVarPrefix) {
+			continue
+		}
+		kv := strings.SplitN(strings.TrimPrefix(e, EnvVarPrefix), "=", 2)
+		if len(kv) == 2 && kv[0] != "" {
+			vars[kv[0]] = kv[1]
+		}
+	}
+	return vars
+}
+
+// ParseVariables parses a list of 'key=value' pairs
+func ParseVariables(pairs []string) (Variables, error) {
+	vars := Variables{}
+	for _, p := range pairs {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid variable '%s', expected key=value", p)
+		}
+		vars[kv[0]] = kv[1]
+	}
+	return vars, nil
+}
+
+// Expand renders the templates in all components' fields, with the
+// manifest's variables overridden by vars. The variables are stored in
+// the components, for rendering YAML sources later on.
+func (m *Manifest) Expand(vars ...Variables) error {
+	merged := m.Variables.Merge(vars...)
+
+	for i := range m.Components {
+		if err := m.Components[i].expand(merged); err != nil {
+			return errors.Wrapf(err, "failed to expand variables in component '%s'", m.Components[i].ID)
+		}
+	}
+	return nil
+}
+
+func (c *Component) expand(vars Variables) error {
+	c.Vars = vars
+
+	fields := []*string{
+		&c.Namespace,
+		&c.Source.Name,
+		&c.Source.Chart,
+		&c.Source.Path,
+		&c.Source.URL,
+		&c.Source.Version,
+		&c.Source.Digest,
+	}
+	if r := c.Source.Registry; r != nil {
+		fields = append(fields, &r.Config)
+	}
+	if g := c.Source.Git; g != nil {
+		fields = append(fields, &g.URL, &g.Ref, &g.Path)
+	}
+	for i := range c.ValuesFiles {
+		fields = append(fields, &c.ValuesFiles[i])
+	}
+	for i := range c.Values {
+		fields = append(fields, &c.Values[i].Name, &c.Values[i].Value)
+		if vs := c.Values[i].ValueFrom; vs != nil {
+			fields = append(fields, &vs.Env, &vs.File)
+			if ref := vs.SecretKeyRef; ref != nil {
+				fields = append(fields, &ref.Name, &ref.Namespace, &ref.Key)
+			}
+		}
+	}
+	if h := c.Hooks; h != nil {
+		for _, hooks := range [][]Hook{h.PreInstall, h.PostInstall, h.PreUninstall, h.PostUninstall} {
+			for i := range hooks {
+				fields = append(fields, &hooks[i].Job)
+				for j := range hooks[i].Command {
+					fields = append(fields, &hooks[i].Command[j])
+				}
+			}
+		}
+	}
+	for _, checks := range [][]ComponentAction{c.PreDelete, c.PreDeploy, c.WaitComplete, c.PreUpgrade, c.PostUpgrade} {
+		for i := range checks {
+			chk := &checks[i]
+			fields = append(fields, &chk.Selector, &chk.Namespace, &chk.Name, &chk.Value,
+				&chk.APIVersion, &chk.Kind, &chk.Condition, &chk.Status, &chk.JSONPath)
+		}
+	}
+
+	for _, f := range fields {
+		s, err := expandString(*f, vars)
+		if err != nil {
+			return err
+		}
+		*f = s
+	}
+
+	if c.ValuesObject != nil {
+		vo, err := expandValues(map[string]interface{}(c.ValuesObject), vars)
+		if err != nil {
+			return err
+		}
+		c.ValuesObject = vo.(map[string]interface{})
+	}
+	return nil
+}
+
+// expandValues returns a copy of the nested values, with all strings expanded
+func expandValues(v interface{}, vars Variables) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			ee, err := expandValues(e, vars)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = ee
+		}
+		return m, nil
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			ee, err := expandValues(e, vars)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = ee
+		}
+		return l, nil
+	case string:
+		return expandString(v, vars)
+	}
+	return v, nil
+}
+
+// expandString renders s as a template, if it contains an action.
+// Missing variables are an error.
+func expandString(s string, vars Variables) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+
+	t, err := template.New("").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	data := struct {
+		Vars Variables
+	}{vars}
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// templateVars returns the variables used by the templates, i.e. the
+// component's YAML source and the jobs of its hooks. If a template can't
+// be parsed or uses the variables as a whole, e.g. 'range .Vars', all are
+// returned.
+func (c Component) templateVars(sources [][]byte) Variables {
+	if len(c.Vars) == 0 {
+		return nil
+	}
+
+	used := Variables{}
+	for _, data := range sources {
+		tmpl, err := parseTemplate(c, data)
+		if err != nil {
+			return c.Vars
+		}
+		names, ok := referencedVars(tmpl)
+		if !ok {
+			return c.Vars
+		}
+		for name := range names {
+			if v, ok := c.Vars[name]; ok {
+				used[name] = v
+			}
+		}
+	}
+	if len(used) == 0 {
+		return nil
+	}
+	return used
+}
+
+// referencedVars returns the names of the variables referenced by
+// '.Vars.<name>' in the template. It returns false if '.Vars' is used
+// otherwise.
+func referencedVars(tmpl *template.Template) (map[string]bool, bool) {
+	names := map[string]bool{}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil && !collectVars(t.Tree.Root, names) {
+			return nil, false
+		}
+	}
+	return names, true
+}
+
+// collectVars adds the names of the variables referenced by
+// '.Vars.<name>' in the template's node to names. It returns false if
+// '.Vars' is used otherwise.
+func collectVars(node parse.Node, names map[string]bool) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return true
+		}
+		for _, e := range n.Nodes {
+			if !collectVars(e, names) {
+				return false
+			}
+		}
+	case *parse.ActionNode:
+		return collectVars(n.Pipe, names)
+	case *parse.IfNode:
+		return collectBranchVars(&n.BranchNode, names)
+	case *parse.RangeNode:
+		return collectBranchVars(&n.BranchNode, names)
+	case *parse.WithNode:
+		return collectBranchVars(&n.BranchNode, names)
+	case *parse.TemplateNode:
+		return collectVars(n.Pipe, names)
+	case *parse.PipeNode:
+		if n == nil {
+			return true
+		}
+		for _, cmd := range n.Cmds {
+			if !collectVars(cmd, names) {
+				return false
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if !collectVars(arg, names) {
+				return false
+			}
+		}
+	case *parse.ChainNode:
+		return collectVars(n.Node, names)
+	case *parse.FieldNode:
+		return collectField(n.Ident, names)
+	case *parse.VariableNode:
+		if len(n.Ident) > 0 && n.Ident[0] == "$" {
+			return collectField(n.Ident[1:], names)
+		}
+	}
+	return true
+}
+
+func collectBranchVars(n *parse.BranchNode, names map[string]bool) bool {
+	return collectVars(n.Pipe, names) &&
+		collectVars(n.List, names) &&
+		collectVars(n.ElseList, names)
+}
+
+// collectField handles field chains like 'Vars.name'
+func collectField(ident []string, names map[string]bool) bool {
+	if len(ident) == 0 || ident[0] != "Vars" {
+		return true
+	}
+	if len(ident) == 1 {
+		return false
+	}
+	names[ident[1]] = true
+	return true
+}
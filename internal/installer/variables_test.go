@@ -0,0 +1,146 @@
+package installer_test
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/epinio/installer/internal/installer"
+)
+
+var _ = Describe("Variables", func() {
+	var m *installer.Manifest
+	var file installer.Variables
+
+	BeforeEach(func() {
+		var err error
+		m, err = installer.Load(assetPath("vars-manifest.yml"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(m.Variables).To(HaveKeyWithValue("namespace", "epinio"))
+
+		file, err = installer.LoadVariables(assetPath("vars.yml"))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("expands templates in the components' fields", func() {
+		err := m.Expand(file)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(m.Components[0].Namespace).To(Equal("epinio"))
+		traefik := m.Components[1]
+		Expect(traefik.Source.URL).To(Equal("https://helm.traefik.io/traefik/traefik-10.3.4.tgz"))
+		Expect(traefik.Values[0].Value).To(Equal("10.0.0.1"))
+		Expect(traefik.WaitComplete[0].Namespace).To(Equal("epinio"))
+		Expect(traefik.WaitComplete[0].Selector).To(Equal("app.kubernetes.io/name=traefik"))
+	})
+
+	It("lets later variables override earlier ones", func() {
+		env := installer.VariablesFromEnv([]string{"PATH=/bin", "EPINIO_VAR_namespace=from-env", "EPINIO_VAR_loadbalancerIP=10.0.0.2"})
+		set, err := installer.ParseVariables([]string{"namespace=from-flag"})
+		Expect(err).ToNot(HaveOccurred())
+
+		err = m.Expand(file, env, set)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(m.Components[0].Namespace).To(Equal("from-flag"))
+		Expect(m.Components[1].Values[0].Value).To(Equal("10.0.0.2"))
+	})
+
+	It("fails on missing variables", func() {
+		err := m.Expand()
+		Expect(err).To(MatchError(ContainSubstring("failed to expand variables in component 'traefik'")))
+	})
+
+	It("rejects invalid pairs", func() {
+		_, err := installer.ParseVariables([]string{"novalue"})
+		Expect(err).To(MatchError("invalid variable 'novalue', expected key=value"))
+	})
+
+	It("renders YAML sources with the variables", func() {
+		err := m.Expand(file)
+		Expect(err).ToNot(HaveOccurred())
+
+		out := &bytes.Buffer{}
+		err = installer.NewDryRun(out, time.Minute, 0, false, nil).Walk(context.TODO(), m.Components)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(out.String()).To(ContainSubstring("  name: letsencrypt-staging\n"))
+		Expect(out.String()).To(ContainSubstring("    email: epinio@epinio.io\n"))
+	})
+
+	It("changes the component's hash, when variables change", func() {
+		other, err := installer.Load(assetPath("vars-manifest.yml"))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(m.Expand(file)).To(Succeed())
+		Expect(other.Expand(file, installer.Variables{"issuer": "letsencrypt-production"})).To(Succeed())
+		Expect(m.Components.ReadContents(context.TODO(), nil)).To(Succeed())
+		Expect(other.Components.ReadContents(context.TODO(), nil)).To(Succeed())
+		Expect(m.Components[2].Hash()).ToNot(Equal(other.Components[2].Hash()))
+	})
+
+	It("keeps the components' hashes, when unused variables change", func() {
+		other, err := installer.Load(assetPath("vars-manifest.yml"))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(m.Expand(file)).To(Succeed())
+		Expect(other.Expand(file, installer.Variables{"unused": "changed"})).To(Succeed())
+		Expect(m.Components.ReadContents(context.TODO(), nil)).To(Succeed())
+		Expect(other.Components.ReadContents(context.TODO(), nil)).To(Succeed())
+		for i := range m.Components {
+			Expect(m.Components[i].Hash()).To(Equal(other.Components[i].Hash()))
+		}
+	})
+
+	It("expands values files and strings in the values object", func() {
+		c := installer.Component{
+			ID:           "hello",
+			ValuesFiles:  []string{"{{ .Vars.dir }}/values.yaml"},
+			ValuesObject: installer.ValuesObject{"hosts": []interface{}{"{{ .Vars.domain }}"}, "replicas": 2},
+		}
+		m := &installer.Manifest{Components: installer.Components{c}}
+		err := m.Expand(installer.Variables{"dir": "/tmp", "domain": "example.com"})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(m.Components[0].ValuesFiles).To(Equal([]string{"/tmp/values.yaml"}))
+		Expect(m.Components[0].ValuesObject).To(Equal(installer.ValuesObject{"hosts": []interface{}{"example.com"}, "replicas": 2}))
+		// the original is not modified
+		Expect(c.ValuesObject["hosts"]).To(Equal([]interface{}{"{{ .Vars.domain }}"}))
+	})
+
+	It("expands the fields of condition checks", func() {
+		c := installer.Component{
+			ID: "issuer",
+			WaitComplete: []installer.ComponentAction{{
+				Type:       installer.Condition,
+				APIVersion: "cert-manager.io/{{ .Vars.version }}",
+				Kind:       "{{ .Vars.kind }}",
+				Name:       "{{ .Vars.issuer }}",
+				Condition:  "{{ .Vars.condition }}",
+				Status:     "{{ .Vars.status }}",
+			}, {
+				Type:     installer.Condition,
+				Kind:     "Certificate",
+				JSONPath: "{.status.{{ .Vars.field }}}",
+				Value:    "{{ .Vars.phase }}",
+			}},
+		}
+		m := &installer.Manifest{Components: installer.Components{c}}
+		err := m.Expand(installer.Variables{
+			"version": "v1", "kind": "ClusterIssuer", "issuer": "letsencrypt-staging",
+			"condition": "Ready", "status": "True", "field": "phase", "phase": "Issued",
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		chk := m.Components[0].WaitComplete
+		Expect(chk[0].APIVersion).To(Equal("cert-manager.io/v1"))
+		Expect(chk[0].Kind).To(Equal("ClusterIssuer"))
+		Expect(chk[0].Name).To(Equal("letsencrypt-staging"))
+		Expect(chk[0].Condition).To(Equal("Ready"))
+		Expect(chk[0].Status).To(Equal("True"))
+		Expect(chk[1].JSONPath).To(Equal("{.status.phase}"))
+		Expect(chk[1].Value).To(Equal("Issued"))
+	})
+})
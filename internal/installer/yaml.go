@@ -1,125 +1,459 @@
 package installer
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
-	"html/template"
-	"os"
+	"io"
+	"sort"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/avast/retry-go"
-	epierr "github.com/epinio/installer/internal/errors"
-	"github.com/epinio/installer/internal/exec"
 	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	yamlutil "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/epinio/installer/internal/duration"
+	epierr "github.com/epinio/installer/internal/errors"
 )
 
-func yamlApply(log logr.Logger, c Component) error {
-	if c.Source.URL != "" {
-		return errors.New("URL not supported by YAML component")
+// FieldManager is the field manager used for server-side apply
+const FieldManager = "epinio-installer"
+
+// YAMLClient applies and deletes the objects of YAML components
+// in-process, using server-side apply
+type YAMLClient struct {
+	dynamic dynamic.Interface
+	mapper  meta.RESTMapper
+	sources *Sources
+}
+
+// NewYAMLClient returns a client using the dynamic client and the mapper
+// to find the resources for the objects' kinds. The YAML is read from
+// sources.
+func NewYAMLClient(dynamic dynamic.Interface, mapper meta.RESTMapper, sources *Sources) *YAMLClient {
+	return &YAMLClient{
+		dynamic: dynamic,
+		mapper:  mapper,
+		sources: sources,
+	}
+}
+
+// Apply creates or updates all objects from the component's source and
+// returns their references
+func (y *YAMLClient) Apply(ctx context.Context, log logr.Logger, c Component) ([]ObjectRef, error) {
+	if c.Source.Path == "" && c.Source.Git == nil && c.Source.URL == "" {
+		return nil, errors.New("Empty path for YAML component")
+	}
+
+	objs, err := loadObjects(ctx, y.sources, c)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("apply", "path", c.Source.location(), "objects", len(objs))
+
+	message := fmt.Sprintf("applying YAML for '%s' from '%s'", c.ID, c.Source.location())
+	err = y.retry(ctx, log, message, func() error {
+		for _, obj := range objs {
+			if err := y.apply(ctx, c, obj); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the namespaces were defaulted when applying
+	refs := make([]ObjectRef, 0, len(objs))
+	for _, obj := range objs {
+		refs = append(refs, newObjectRef(obj))
 	}
+	return refs, nil
+}
 
-	if c.Source.Path == "" {
-		return errors.New("Empty path for YAML component")
+// Delete removes all objects from the component's source, in reverse
+// order, and waits for them to be gone. Missing objects and kinds are
+// ignored.
+func (y *YAMLClient) Delete(ctx context.Context, log logr.Logger, c Component) error {
+	objs, err := loadObjects(ctx, y.sources, c)
+	if err != nil {
+		return err
 	}
 
-	path := c.Source.Path
-	if len(c.Values) > 0 {
-		var err error
-		path, err = tmpl(c.String(), c.Source.Path, c.Values)
+	log.Info("delete", "path", c.Source.location(), "objects", len(objs))
+
+	message := fmt.Sprintf("deleting YAML for '%s' from '%s'", c.ID, c.Source.location())
+	return y.retry(ctx, log, message, func() error {
+		for i := len(objs) - 1; i >= 0; i-- {
+			if err := y.delete(ctx, c, objs[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Missing returns the objects from the component's source, which don't
+// exist in the cluster, e.g. "ConfigMap 'config'"
+func (y *YAMLClient) Missing(ctx context.Context, c Component) ([]string, error) {
+	objs, err := loadObjects(ctx, y.sources, c)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := []string{}
+	for _, obj := range objs {
+		ri, err := y.resource(c, obj)
+		if err == nil {
+			_, err = ri.Get(ctx, obj.GetName(), metav1.GetOptions{})
+		}
+		if meta.IsNoMatchError(err) || apierrors.IsNotFound(err) {
+			missing = append(missing, fmt.Sprintf("%s '%s'", obj.GetKind(), obj.GetName()))
+			continue
+		}
 		if err != nil {
-			return err
+			return nil, errors.Wrapf(err, "failed to get %s '%s'", obj.GetKind(), obj.GetName())
 		}
-		defer os.Remove(path)
 	}
+	return missing, nil
+}
 
-	args := []string{"apply", "--wait", "--filename", path}
+// Diff compares the objects from the component's source with the live
+// objects. Applied objects, which were removed from the source but still
+// exist, are reported as removed.
+func (y *YAMLClient) Diff(ctx context.Context, c Component, applied []ObjectRef) ([]Change, error) {
+	objs, err := loadObjects(ctx, y.sources, c)
+	if err != nil {
+		return nil, err
+	}
 
-	// Note: providing this namespace will error if the yaml already defines a different one
-	if c.Namespace != "" {
-		args = append(args, "--namespace", c.Namespace)
+	live := []*unstructured.Unstructured{}
+	for _, obj := range objs {
+		ri, err := y.resource(c, obj)
+		if meta.IsNoMatchError(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		l, err := ri.Get(ctx, obj.GetName(), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get %s '%s'", obj.GetKind(), obj.GetName())
+		}
+		live = append(live, l)
+	}
+	changes := diffObjectLists(objs, live, false)
+
+	desired := map[string]bool{}
+	for _, obj := range objs {
+		desired[objectName(obj)] = true
+	}
+	for _, ref := range applied {
+		obj := ref.object()
+		if desired[objectName(obj)] {
+			continue
+		}
+		exists, err := y.exists(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			changes = append(changes, Change{Type: Removed, Resource: objectName(obj)})
+		}
+	}
+	return changes, nil
+}
+
+// exists is true if the referenced object exists, unknown kinds are missing
+func (y *YAMLClient) exists(ctx context.Context, ref ObjectRef) (bool, error) {
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return false, err
+	}
+	mapping, err := y.mapper.RESTMapping(schema.GroupKind{Group: gv.Group, Kind: ref.Kind}, gv.Version)
+	if meta.IsNoMatchError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var ri dynamic.ResourceInterface = y.dynamic.Resource(mapping.Resource)
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		ri = y.dynamic.Resource(mapping.Resource).Namespace(ref.Namespace)
+	}
+	_, err = ri.Get(ctx, ref.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to get %s '%s'", ref.Kind, ref.Name)
+	}
+	return true, nil
+}
+
+func (y *YAMLClient) apply(ctx context.Context, c Component, obj *unstructured.Unstructured) error {
+	ri, err := y.resource(c, obj)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+
+	force := true
+	_, err = ri.Patch(ctx, obj.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
+		FieldManager: FieldManager,
+		Force:        &force,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to apply %s '%s'", obj.GetKind(), obj.GetName())
+	}
+	return nil
+}
+
+func (y *YAMLClient) delete(ctx context.Context, c Component, obj *unstructured.Unstructured) error {
+	ri, err := y.resource(c, obj)
+	if meta.IsNoMatchError(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	policy := metav1.DeletePropagationBackground
+	err = ri.Delete(ctx, obj.GetName(), metav1.DeleteOptions{PropagationPolicy: &policy})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete %s '%s'", obj.GetKind(), obj.GetName())
+	}
+
+	return wait.PollImmediate(time.Second, duration.ToDeployment(), func() (bool, error) {
+		_, err := ri.Get(ctx, obj.GetName(), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+}
+
+// resource maps the object's kind to a resource client, for namespaced
+// resources the namespace defaults to the component's
+func (y *YAMLClient) resource(c Component, obj *unstructured.Unstructured) (dynamic.ResourceInterface, error) {
+	gvk := obj.GroupVersionKind()
+	mapping, err := y.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return y.dynamic.Resource(mapping.Resource), nil
+	}
+
+	namespace := obj.GetNamespace()
+	if namespace == "" {
+		namespace = c.Namespace
+	}
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	// Note: like kubectl, a different namespace in the yaml is an error
+	if c.Namespace != "" && namespace != c.Namespace {
+		return nil, fmt.Errorf("%s '%s' is in namespace '%s', but the component is in '%s'", obj.GetKind(), obj.GetName(), namespace, c.Namespace)
 	}
+	obj.SetNamespace(namespace)
 
-	log.Info("run", "args", args)
+	return y.dynamic.Resource(mapping.Resource).Namespace(namespace), nil
+}
 
-	message := fmt.Sprintf("applying YAML for '%s' from '%s'", c.ID, c.Source.Path)
-	retryErr := retry.Do(
+// retry runs f until it succeeds or fails with an error that can't be
+// retried. If kinds are missing, the mapper is reset, in case their CRDs
+// were just installed.
+func (y *YAMLClient) retry(ctx context.Context, log logr.Logger, message string, f func() error) error {
+	return retry.Do(
 		func() error {
-			out, err := exec.Kubectl(args...)
-			if err != nil {
-				return errors.Wrap(err, fmt.Sprintf("%s failed:\n%s", message, out))
+			if err := f(); err != nil {
+				return errors.Wrap(err, message+" failed")
 			}
 			return nil
 		},
 		retry.RetryIf(func(err error) bool {
+			if meta.IsNoMatchError(errors.Cause(err)) {
+				if m, ok := y.mapper.(meta.ResettableRESTMapper); ok {
+					m.Reset()
+					return true
+				}
+				return false
+			}
 			return epierr.Retryable(err.Error())
 		}),
 		retry.OnRetry(func(n uint, err error) {
-			log.V(1).Info("retrying to apply", "path", c.Source.Path)
+			log.V(1).Info("retrying", "message", message, "error", err.Error())
 		}),
 		retry.Delay(5*time.Second),
+		retry.Context(ctx),
 	)
-	return retryErr
 }
 
-func yamlDelete(log logr.Logger, c Component) error {
-	path := c.Source.Path
-	if len(c.Values) > 0 {
-		var err error
-		path, err = tmpl(c.String(), c.Source.Path, c.Values)
+// loadObjects reads all objects from the component's source, rendering
+// it as a template if the component is one
+func loadObjects(ctx context.Context, sources *Sources, c Component) ([]*unstructured.Unstructured, error) {
+	data, err := sources.read(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return parseObjects(c, c.Source.location(), data, c.isTemplate())
+}
+
+// loadObjectsFrom reads all objects from the file at path, always
+// rendering it as a template
+func loadObjectsFrom(sources *Sources, c Component, path string) ([]*unstructured.Unstructured, error) {
+	data, err := sources.readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseObjects(c, path, data, true)
+}
+
+// parseObjects decodes all objects from data, which was read from name,
+// after rendering it as a template if render is set
+func parseObjects(c Component, name string, data []byte, render bool) ([]*unstructured.Unstructured, error) {
+	if render {
+		rendered, err := renderTemplate(c, data)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		defer os.Remove(path)
+		data = []byte(rendered)
 	}
 
-	args := []string{"delete", "--wait", "--filename", path}
-	if c.Namespace != "" {
-		args = append(args, "--namespace", c.Namespace)
+	objs, err := decodeObjects(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse YAML for '%s' from '%s'", c.ID, name)
 	}
+	return objs, nil
+}
 
-	log.Info("run", "args", args)
+// decodeObjects parses a multi-document YAML or JSON stream, lists are
+// expanded into their items and empty documents are skipped
+func decodeObjects(r io.Reader) ([]*unstructured.Unstructured, error) {
+	objs := []*unstructured.Unstructured{}
 
-	message := fmt.Sprintf("deleting YAML for '%s' from '%s'", c.ID, c.Source.Path)
-	retryErr := retry.Do(
-		func() error {
-			out, err := exec.Kubectl(args...)
+	decoder := yamlutil.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(&obj.Object); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if len(obj.Object) == 0 {
+			continue
+		}
+
+		if obj.IsList() {
+			err := obj.EachListItem(func(o runtime.Object) error {
+				objs = append(objs, o.(*unstructured.Unstructured))
+				return nil
+			})
 			if err != nil {
-				if strings.Contains(out, "not found") || strings.Contains(out, "no matches") {
-					return nil
-				}
-				return errors.Wrap(err, fmt.Sprintf("%s failed:\n%s", message, out))
+				return nil, err
 			}
-			return nil
-		},
-		retry.RetryIf(func(err error) bool {
-			return epierr.Retryable(err.Error())
-		}),
-		retry.OnRetry(func(n uint, err error) {
-			log.V(1).Info("retrying to delete", "path", c.Source.Path)
-		}),
-		retry.Delay(5*time.Second),
-	)
+			continue
+		}
 
-	return retryErr
+		if obj.GetKind() == "" {
+			return nil, fmt.Errorf("object '%s' has no kind", obj.GetName())
+		}
+		objs = append(objs, obj)
+	}
+
+	return objs, nil
 }
 
-func tmpl(id string, path string, values Values) (string, error) {
-	dat, err := os.ReadFile(path)
+// kubectlArgs returns the kubectl arguments equivalent to applying or
+// deleting the YAML at path, it is used to describe the installation
+func kubectlArgs(verb string, c Component, path string) []string {
+	args := []string{verb}
+	if verb == "apply" {
+		args = append(args, "--server-side", "--force-conflicts", "--field-manager", FieldManager)
+	} else {
+		args = append(args, "--wait", "--ignore-not-found")
+	}
+	args = append(args, "--filename", path)
+
+	if c.Namespace != "" {
+		args = append(args, "--namespace", c.Namespace)
+	}
+	return args
+}
+
+// isTemplate is true if the YAML source is rendered before it is applied,
+// because the component has values or sets 'template'
+func (c Component) isTemplate() bool {
+	return len(c.Values) > 0 || c.Source.Template
+}
+
+// parseTemplate parses the YAML as a template. Missing keys render as
+// empty strings, like they did with html/template.
+func parseTemplate(c Component, dat []byte) (*template.Template, error) {
+	tmpl, err := template.New(c.String()).Option("missingkey=zero").Parse(string(dat))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse template for '%s'", c.ID)
+	}
+	return tmpl, nil
+}
+
+// renderTemplate executes the template with the component's values and
+// the manifest's variables. Missing values render as empty strings, but
+// referencing a variable, which is not set, is an error.
+func renderTemplate(c Component, dat []byte) (string, error) {
+	tmpl, err := parseTemplate(c, dat)
 	if err != nil {
 		return "", err
 	}
-	tmpl := template.Must(template.New(string(id)).Parse(string(dat)))
+	if names, ok := referencedVars(tmpl); ok {
+		missing := []string{}
+		for name := range names {
+			if _, ok := c.Vars[name]; !ok {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			return "", fmt.Errorf("failed to render template for '%s': variables not set: %s", c.ID, strings.Join(missing, ", "))
+		}
+	}
 	var config strings.Builder
 	data := struct {
 		Values map[string]string
-	}{values.ToMap()}
+		Vars   Variables
+	}{c.Values.ToMap(), c.Vars}
 	if err := tmpl.Execute(&config, data); err != nil {
-		return "", err
-	}
-	tmpfile, err := exec.CreateTmpFile(config.String())
-	if err != nil {
-		return "", err
+		return "", errors.Wrapf(err, "failed to render template for '%s'", c.ID)
 	}
-	return tmpfile, nil
+	return config.String(), nil
 }
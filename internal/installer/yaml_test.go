@@ -0,0 +1,356 @@
+package installer_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/epinio/installer/internal/installer"
+)
+
+var _ = Describe("YAMLClient", func() {
+	var dyn *dynamicfake.FakeDynamicClient
+	var client *installer.YAMLClient
+	var c installer.Component
+	var patches []k8stesting.PatchAction
+
+	namespaces := schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+	configmaps := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	serviceaccounts := schema.GroupVersionResource{Version: "v1", Resource: "serviceaccounts"}
+	secrets := schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+
+	BeforeEach(func() {
+		mapper := meta.NewDefaultRESTMapper(nil)
+		mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+		mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+		mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ServiceAccount"}, meta.RESTScopeNamespace)
+		mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Secret"}, meta.RESTScopeNamespace)
+
+		dyn = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+			namespaces:      "NamespaceList",
+			configmaps:      "ConfigMapList",
+			serviceaccounts: "ServiceAccountList",
+			secrets:         "SecretList",
+		})
+		client = installer.NewYAMLClient(dyn, mapper, nil)
+
+		// the fake client does not implement server-side apply
+		patches = []k8stesting.PatchAction{}
+		dyn.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			patches = append(patches, action.(k8stesting.PatchAction))
+			return true, nil, nil
+		})
+
+		c = installer.Component{
+			ID:        "tekton",
+			Type:      installer.YAML,
+			Namespace: "tekton-pipelines",
+			Source:    installer.Source{Path: assetPath("multi-document.yaml")},
+		}
+	})
+
+	It("applies all documents server-side", func() {
+		refs, err := client.Apply(context.TODO(), logr.Discard(), c)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(refs).To(Equal([]installer.ObjectRef{
+			{APIVersion: "v1", Kind: "Namespace", Name: "tekton-pipelines"},
+			{APIVersion: "v1", Kind: "ConfigMap", Namespace: "tekton-pipelines", Name: "feature-flags"},
+			{APIVersion: "v1", Kind: "ServiceAccount", Namespace: "tekton-pipelines", Name: "tekton-pipelines-controller"},
+		}))
+
+		Expect(patches).To(HaveLen(3))
+		for _, p := range patches {
+			Expect(p.GetPatchType()).To(Equal(types.ApplyPatchType))
+		}
+		Expect(patches[0].GetResource()).To(Equal(namespaces))
+		Expect(patches[0].GetName()).To(Equal("tekton-pipelines"))
+		Expect(patches[1].GetResource()).To(Equal(configmaps))
+		Expect(patches[1].GetNamespace()).To(Equal("tekton-pipelines"))
+		Expect(patches[2].GetResource()).To(Equal(serviceaccounts))
+		Expect(patches[2].GetName()).To(Equal("tekton-pipelines-controller"))
+	})
+
+	It("rejects objects in a different namespace than the component", func() {
+		c.Namespace = "other"
+		_, err := client.Apply(context.TODO(), logr.Discard(), c)
+		Expect(err).To(MatchError(ContainSubstring("is in namespace 'tekton-pipelines', but the component is in 'other'")))
+	})
+
+	It("deletes all documents and ignores missing objects", func() {
+		cm := &unstructured.Unstructured{}
+		cm.SetAPIVersion("v1")
+		cm.SetKind("ConfigMap")
+		cm.SetName("feature-flags")
+		cm.SetNamespace("tekton-pipelines")
+		_, err := dyn.Resource(configmaps).Namespace("tekton-pipelines").Create(context.TODO(), cm, metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		err = client.Delete(context.TODO(), logr.Discard(), c)
+		Expect(err).ToNot(HaveOccurred())
+
+		list, err := dyn.Resource(configmaps).Namespace("tekton-pipelines").List(context.TODO(), metav1.ListOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(list.Items).To(BeEmpty())
+	})
+
+	It("ignores unknown kinds on delete", func() {
+		client = installer.NewYAMLClient(dyn, meta.NewDefaultRESTMapper(nil), nil)
+		err := client.Delete(context.TODO(), logr.Discard(), c)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("lists the missing objects", func() {
+		cm := &unstructured.Unstructured{}
+		cm.SetAPIVersion("v1")
+		cm.SetKind("ConfigMap")
+		cm.SetName("feature-flags")
+		cm.SetNamespace("tekton-pipelines")
+		_, err := dyn.Resource(configmaps).Namespace("tekton-pipelines").Create(context.TODO(), cm, metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		missing, err := client.Missing(context.TODO(), c)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(missing).To(Equal([]string{"Namespace 'tekton-pipelines'", "ServiceAccount 'tekton-pipelines-controller'"}))
+	})
+
+	It("lists objects of unknown kinds as missing", func() {
+		client = installer.NewYAMLClient(dyn, meta.NewDefaultRESTMapper(nil), nil)
+		missing, err := client.Missing(context.TODO(), c)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(missing).To(HaveLen(3))
+	})
+
+	It("diffs the objects with the live objects", func() {
+		cm := &unstructured.Unstructured{}
+		cm.SetAPIVersion("v1")
+		cm.SetKind("ConfigMap")
+		cm.SetName("feature-flags")
+		cm.SetNamespace("tekton-pipelines")
+		cm.SetUID("defaulted-by-the-server")
+		Expect(unstructured.SetNestedStringMap(cm.Object, map[string]string{
+			"disable-affinity-assistant": "true",
+			"other":                      "ignored",
+		}, "data")).To(Succeed())
+		_, err := dyn.Resource(configmaps).Namespace("tekton-pipelines").Create(context.TODO(), cm, metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		changes, err := client.Diff(context.TODO(), c, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes).To(Equal([]installer.Change{
+			{Type: installer.Added, Resource: "Namespace 'tekton-pipelines'"},
+			{Type: installer.Changed, Resource: "ConfigMap 'tekton-pipelines/feature-flags'", Details: []string{`data.disable-affinity-assistant: "true" -> "false"`}},
+			{Type: installer.Added, Resource: "ServiceAccount 'tekton-pipelines/tekton-pipelines-controller'"},
+		}))
+	})
+
+	It("reports applied objects, which were removed from the source", func() {
+		for _, name := range []string{"feature-flags", "old-flags"} {
+			cm := &unstructured.Unstructured{}
+			cm.SetAPIVersion("v1")
+			cm.SetKind("ConfigMap")
+			cm.SetName(name)
+			cm.SetNamespace("tekton-pipelines")
+			Expect(unstructured.SetNestedStringMap(cm.Object, map[string]string{"disable-affinity-assistant": "false"}, "data")).To(Succeed())
+			_, err := dyn.Resource(configmaps).Namespace("tekton-pipelines").Create(context.TODO(), cm, metav1.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		applied := []installer.ObjectRef{
+			{APIVersion: "v1", Kind: "ConfigMap", Namespace: "tekton-pipelines", Name: "feature-flags"},
+			{APIVersion: "v1", Kind: "ConfigMap", Namespace: "tekton-pipelines", Name: "old-flags"},
+			// deleted already, or of unknown kinds
+			{APIVersion: "v1", Kind: "ConfigMap", Namespace: "tekton-pipelines", Name: "deleted"},
+			{APIVersion: "example.com/v1", Kind: "Widget", Name: "unknown"},
+		}
+		changes, err := client.Diff(context.TODO(), c, applied)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changes).To(Equal([]installer.Change{
+			{Type: installer.Added, Resource: "Namespace 'tekton-pipelines'"},
+			{Type: installer.Added, Resource: "ServiceAccount 'tekton-pipelines/tekton-pipelines-controller'"},
+			{Type: installer.Removed, Resource: "ConfigMap 'tekton-pipelines/old-flags'"},
+		}))
+	})
+
+	Context("with a secret", func() {
+		createSecret := func(data map[string]string) {
+			secret := &unstructured.Unstructured{}
+			secret.SetAPIVersion("v1")
+			secret.SetKind("Secret")
+			secret.SetName("credentials")
+			secret.SetNamespace("tekton-pipelines")
+			if data != nil {
+				Expect(unstructured.SetNestedStringMap(secret.Object, data, "data")).To(Succeed())
+			}
+			_, err := dyn.Resource(secrets).Namespace("tekton-pipelines").Create(context.TODO(), secret, metav1.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		BeforeEach(func() {
+			c.Source = installer.Source{Path: assetPath("secret.yaml")}
+		})
+
+		It("compares stringData with the encoded data", func() {
+			createSecret(map[string]string{"password": "aHVudGVyMg=="})
+			changes, err := client.Diff(context.TODO(), c, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes).To(BeEmpty())
+		})
+
+		It("doesn't show changed data", func() {
+			createSecret(map[string]string{"password": "b2xk"})
+			changes, err := client.Diff(context.TODO(), c, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes).To(Equal([]installer.Change{
+				{Type: installer.Changed, Resource: "Secret 'tekton-pipelines/credentials'", Details: []string{"data.password: changed"}},
+			}))
+		})
+
+		It("doesn't show data missing in the cluster", func() {
+			createSecret(nil)
+			changes, err := client.Diff(context.TODO(), c, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes).To(Equal([]installer.Change{
+				{Type: installer.Changed, Resource: "Secret 'tekton-pipelines/credentials'", Details: []string{"data: changed"}},
+			}))
+		})
+	})
+
+	Context("with variables", func() {
+		BeforeEach(func() {
+			c.Vars = installer.Variables{"password": "a+b'c"}
+		})
+
+		It("applies sources without template as is", func() {
+			c.Source = installer.Source{Path: assetPath("literal-braces.yaml")}
+			_, err := client.Apply(context.TODO(), logr.Discard(), c)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(patches).To(HaveLen(1))
+			Expect(string(patches[0].GetPatch())).To(ContainSubstring(`"script":"echo {{ inputs.params.name }}"`))
+		})
+
+		It("renders templates without escaping", func() {
+			c.Source = installer.Source{Path: assetPath("vars-configmap.yaml"), Template: true}
+			_, err := client.Apply(context.TODO(), logr.Discard(), c)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(patches).To(HaveLen(1))
+			Expect(string(patches[0].GetPatch())).To(ContainSubstring(`"password":"a+b'c"`))
+		})
+
+		It("renders missing values as empty strings", func() {
+			c.Source = installer.Source{Path: assetPath("vars-configmap.yaml"), Template: true}
+			_, err := client.Apply(context.TODO(), logr.Discard(), c)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(patches).To(HaveLen(1))
+			Expect(string(patches[0].GetPatch())).To(ContainSubstring(`"user":""`))
+		})
+
+		It("fails on missing variables", func() {
+			c.Source = installer.Source{Path: assetPath("vars-configmap.yaml"), Template: true}
+			c.Vars = installer.Variables{}
+			_, err := client.Apply(context.TODO(), logr.Discard(), c)
+			Expect(err).To(MatchError(ContainSubstring(`failed to render template for 'tekton'`)))
+			Expect(err).To(MatchError(ContainSubstring(`variables not set: password`)))
+		})
+	})
+
+	Context("with a URL source", func() {
+		var server *httptest.Server
+		var sum string
+
+		BeforeEach(func() {
+			b, err := ioutil.ReadFile(assetPath("multi-document.yaml"))
+			Expect(err).ToNot(HaveOccurred())
+			hash := sha256.Sum256(b)
+			sum = hex.EncodeToString(hash[:])
+
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/release.yaml":
+					_, _ = w.Write(b)
+				case "/large.yaml":
+					_, _ = io.CopyN(w, zeros{}, 64<<20+1)
+				case "/stalled.yaml":
+					<-r.Context().Done()
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			c.Source = installer.Source{URL: server.URL + "/release.yaml"}
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("applies the downloaded documents", func() {
+			c.Source.SHA256 = sum
+			_, err := client.Apply(context.TODO(), logr.Discard(), c)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(patches).To(HaveLen(3))
+		})
+
+		It("fails if the checksum doesn't match", func() {
+			c.Source.SHA256 = strings.Repeat("0", 64)
+			_, err := client.Apply(context.TODO(), logr.Discard(), c)
+			Expect(err).To(MatchError(fmt.Sprintf("sha256 of '%s/release.yaml' is %s, expected %s", server.URL, sum, c.Source.SHA256)))
+			Expect(patches).To(BeEmpty())
+		})
+
+		It("fails for missing documents", func() {
+			c.Source.URL = server.URL + "/missing.yaml"
+			_, err := client.Apply(context.TODO(), logr.Discard(), c)
+			Expect(err).To(MatchError(ContainSubstring("404 Not Found")))
+		})
+
+		It("fails for documents larger than 64MiB", func() {
+			c.Source.URL = server.URL + "/large.yaml"
+			_, err := client.Apply(context.TODO(), logr.Discard(), c)
+			Expect(err).To(MatchError(ContainSubstring("larger than 67108864 bytes")))
+		})
+
+		It("stops stalled downloads when the context is done", func() {
+			ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+			defer cancel()
+			c.Source.URL = server.URL + "/stalled.yaml"
+			_, err := client.Apply(ctx, logr.Discard(), c)
+			Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+		})
+	})
+
+	It("verifies the checksum of local files", func() {
+		c.Source.SHA256 = strings.Repeat("0", 64)
+		_, err := client.Apply(context.TODO(), logr.Discard(), c)
+		Expect(err).To(MatchError(ContainSubstring("sha256 of '" + assetPath("multi-document.yaml") + "' is ")))
+	})
+})
+
+// zeros reads an endless stream of zero bytes
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
@@ -9,17 +9,20 @@ import (
 
 	kubeconfig "github.com/epinio/epinio/helpers/kubernetes/config"
 
-	appsv1 "k8s.io/api/apps/v1"
 	apibatchv1 "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	apiextensions "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	typedbatchv1 "k8s.io/client-go/kubernetes/typed/batch/v1"
 	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 
 	// https://github.com/kubernetes/client-go/issues/345
 	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
@@ -30,6 +33,8 @@ var clusterMemo *Cluster
 
 type Cluster struct {
 	Kubectl    *kubernetes.Clientset
+	Dynamic    dynamic.Interface
+	Mapper     meta.RESTMapper
 	RestConfig *restclient.Config
 }
 
@@ -60,6 +65,15 @@ func GetCluster(ctx context.Context) (*Cluster, error) {
 	}
 	c.Kubectl = clientset
 
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	c.Dynamic = dynamicClient
+
+	// the mapper discovers resources lazily and can be reset, when new CRDs are installed
+	c.Mapper = restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(clientset.Discovery()))
+
 	clusterMemo = c
 
 	return clusterMemo, nil
@@ -84,30 +98,27 @@ func (c *Cluster) DeploymentExists(ctx context.Context, namespace, deploymentNam
 // provide that CRD and want to make sure the CRD is ready for consumption before
 // continuing deploying things that will consume it.
 func (c *Cluster) WaitForCRD(ctx context.Context, CRDName string, timeout time.Duration) error {
-	clientset, err := apiextensions.NewForConfig(c.RestConfig)
+	cond, err := c.IsCRDEstablished(ctx, CRDName)
 	if err != nil {
 		return err
 	}
+	return wait.PollImmediate(time.Second, timeout, cond)
+}
 
-	err = wait.PollImmediate(time.Second, timeout, func() (bool, error) {
-		_, err = clientset.ApiextensionsV1().CustomResourceDefinitions().Get(ctx, CRDName, metav1.GetOptions{})
-		if err != nil {
-			if apierrors.IsNotFound(err) {
-				return false, nil
-			}
-			return false, err
-		}
-
-		return true, nil
-	})
+// IsCRDEstablished returns a condition function that indicates whether the
+// CRD exists and reached the condition "established"
+func (c *Cluster) IsCRDEstablished(ctx context.Context, CRDName string) (wait.ConditionFunc, error) {
+	clientset, err := apiextensions.NewForConfig(c.RestConfig)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Now wait until the CRD is "established"
-	return wait.PollImmediate(time.Second, timeout, func() (bool, error) {
+	return func() (bool, error) {
 		crd, err := clientset.ApiextensionsV1().CustomResourceDefinitions().Get(ctx, CRDName, metav1.GetOptions{})
 		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return false, nil
+			}
 			return false, err
 		}
 
@@ -117,7 +128,7 @@ func (c *Cluster) WaitForCRD(ctx context.Context, CRDName string, timeout time.D
 			}
 		}
 		return false, nil
-	})
+	}, nil
 }
 
 // IsJobCompleted returns a condition function that indicates whether the given
@@ -146,15 +157,10 @@ func (c *Cluster) WaitForJobCompleted(ctx context.Context, namespace, jobName st
 	return wait.PollImmediate(time.Second, timeout, c.IsJobCompleted(ctx, client, jobName, namespace))
 }
 
-// WaitForSecret waits until the specified secret exists. If timeout is reached,
-// an error is returned.
-// It should be used when something is expected to create a Secret and the code
-// needs to wait until that happens.
-func (c *Cluster) WaitForSecret(ctx context.Context, namespace, secretName string, timeout time.Duration) (*v1.Secret, error) {
-	var secret *v1.Secret
-	waitErr := wait.PollImmediate(time.Second, timeout, func() (bool, error) {
-		var err error
-		secret, err = c.GetSecret(ctx, namespace, secretName)
+// SecretExists returns a condition function that indicates whether the secret exists
+func (c *Cluster) SecretExists(ctx context.Context, namespace, secretName string) wait.ConditionFunc {
+	return func() (bool, error) {
+		_, err := c.GetSecret(ctx, namespace, secretName)
 		if err != nil {
 			if apierrors.IsNotFound(err) {
 				return false, nil
@@ -162,27 +168,33 @@ func (c *Cluster) WaitForSecret(ctx context.Context, namespace, secretName strin
 			return false, err
 		}
 		return true, nil
-	})
+	}
+}
 
-	return secret, waitErr
+// WaitForSecret waits until the specified secret exists. If timeout is reached,
+// an error is returned.
+// It should be used when something is expected to create a Secret and the code
+// needs to wait until that happens.
+func (c *Cluster) WaitForSecret(ctx context.Context, namespace, secretName string, timeout time.Duration) (*v1.Secret, error) {
+	if err := wait.PollImmediate(time.Second, timeout, c.SecretExists(ctx, namespace, secretName)); err != nil {
+		return nil, err
+	}
+	return c.GetSecret(ctx, namespace, secretName)
 }
 
 // IsDeploymentCompleted returns a condition function that indicates whether the given
-// Deployment is in Completed state or not.
+// Deployment exists and its rollout is complete, like 'kubectl rollout status'.
 func (c *Cluster) IsDeploymentCompleted(ctx context.Context, deploymentName, namespace string) wait.ConditionFunc {
 	return func() (bool, error) {
 		deployment, err := c.Kubectl.AppsV1().Deployments(namespace).Get(ctx,
 			deploymentName, metav1.GetOptions{})
 		if err != nil {
-			return false, err
-		}
-
-		for _, condition := range deployment.Status.Conditions {
-			if condition.Type == appsv1.DeploymentAvailable && condition.Status == v1.ConditionTrue {
-				return true, nil
+			if apierrors.IsNotFound(err) {
+				return false, nil
 			}
+			return false, err
 		}
-		return false, nil
+		return DeploymentRolledOut(deployment)
 	}
 }
 
@@ -220,7 +232,13 @@ func (c *Cluster) WaitUntilDeploymentExists(ctx context.Context, namespace, depl
 }
 
 func (c *Cluster) WaitUntilServiceHasLoadBalancer(ctx context.Context, namespace, serviceName string, timeout time.Duration) error {
-	return wait.PollImmediate(time.Second, timeout, func() (bool, error) {
+	return wait.PollImmediate(time.Second, timeout, c.ServiceHasLoadBalancer(ctx, namespace, serviceName))
+}
+
+// ServiceHasLoadBalancer returns a condition function that indicates whether
+// the service has a load balancer ingress
+func (c *Cluster) ServiceHasLoadBalancer(ctx context.Context, namespace, serviceName string) wait.ConditionFunc {
+	return func() (bool, error) {
 		service, err := c.Kubectl.CoreV1().Services(namespace).Get(ctx, serviceName, metav1.GetOptions{})
 		if err != nil {
 			return false, err
@@ -231,7 +249,7 @@ func (c *Cluster) WaitUntilServiceHasLoadBalancer(ctx context.Context, namespace
 		}
 
 		return true, nil
-	})
+	}
 }
 
 // WaitForPodBySelectorRunning waits timeout for all pods in 'namespace'
@@ -0,0 +1,53 @@
+package kubernetes
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// RESTClientGetter returns a RESTClientGetter for the cluster, with
+// namespace as the default namespace. This is what the helm SDK needs to
+// talk to the cluster.
+func (c *Cluster) RESTClientGetter(namespace string) genericclioptions.RESTClientGetter {
+	return &restClientGetter{config: c.RestConfig, namespace: namespace}
+}
+
+type restClientGetter struct {
+	config    *restclient.Config
+	namespace string
+}
+
+var _ genericclioptions.RESTClientGetter = &restClientGetter{}
+
+func (g *restClientGetter) ToRESTConfig() (*restclient.Config, error) {
+	return restclient.CopyConfig(g.config), nil
+}
+
+func (g *restClientGetter) ToDiscoveryClient() (discovery.CachedDiscoveryInterface, error) {
+	dc, err := discovery.NewDiscoveryClientForConfig(g.config)
+	if err != nil {
+		return nil, err
+	}
+	return memory.NewMemCacheClient(dc), nil
+}
+
+func (g *restClientGetter) ToRESTMapper() (meta.RESTMapper, error) {
+	dc, err := g.ToDiscoveryClient()
+	if err != nil {
+		return nil, err
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(dc)
+	return restmapper.NewShortcutExpander(mapper, dc), nil
+}
+
+// ToRawKubeConfigLoader is only used to look up the default namespace
+func (g *restClientGetter) ToRawKubeConfigLoader() clientcmd.ClientConfig {
+	overrides := &clientcmd.ConfigOverrides{Context: clientcmdapi.Context{Namespace: g.namespace}}
+	return clientcmd.NewDefaultClientConfig(*clientcmdapi.NewConfig(), overrides)
+}
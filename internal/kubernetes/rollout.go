@@ -0,0 +1,105 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// IsStatefulSetCompleted returns a condition function that indicates whether
+// the stateful set exists and its rollout is complete, like IsDeploymentCompleted
+func (c *Cluster) IsStatefulSetCompleted(ctx context.Context, name, namespace string) wait.ConditionFunc {
+	return func() (bool, error) {
+		s, err := c.Kubectl.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return StatefulSetRolledOut(s), nil
+	}
+}
+
+// IsDaemonSetCompleted returns a condition function that indicates whether
+// the daemon set exists and its rollout is complete, like IsDeploymentCompleted
+func (c *Cluster) IsDaemonSetCompleted(ctx context.Context, name, namespace string) wait.ConditionFunc {
+	return func() (bool, error) {
+		d, err := c.Kubectl.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return DaemonSetRolledOut(d), nil
+	}
+}
+
+// DeploymentRolledOut is true if all replicas are updated and available.
+// An error is returned if the deployment exceeded its progress deadline.
+func DeploymentRolledOut(d *appsv1.Deployment) (bool, error) {
+	if d.Generation > d.Status.ObservedGeneration {
+		return false, nil
+	}
+
+	for _, cond := range d.Status.Conditions {
+		if cond.Type == appsv1.DeploymentProgressing && cond.Reason == "ProgressDeadlineExceeded" {
+			return false, fmt.Errorf("deployment '%s' exceeded its progress deadline", d.Name)
+		}
+	}
+
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+	if d.Status.UpdatedReplicas < replicas {
+		return false, nil
+	}
+	// old replicas are still terminating
+	if d.Status.Replicas > d.Status.UpdatedReplicas {
+		return false, nil
+	}
+	return d.Status.AvailableReplicas >= d.Status.UpdatedReplicas, nil
+}
+
+// StatefulSetRolledOut is true if all replicas are ready and, for
+// rolling updates, run the current revision
+func StatefulSetRolledOut(s *appsv1.StatefulSet) bool {
+	if s.Generation > s.Status.ObservedGeneration {
+		return false
+	}
+
+	replicas := int32(1)
+	if s.Spec.Replicas != nil {
+		replicas = *s.Spec.Replicas
+	}
+	if s.Status.ReadyReplicas < replicas {
+		return false
+	}
+
+	if s.Spec.UpdateStrategy.Type == appsv1.OnDeleteStatefulSetStrategyType {
+		return true
+	}
+	if ru := s.Spec.UpdateStrategy.RollingUpdate; ru != nil && ru.Partition != nil && *ru.Partition > 0 {
+		// a partitioned rollout only updates the replicas above the partition
+		return s.Status.UpdatedReplicas >= replicas-*ru.Partition
+	}
+	return s.Status.UpdateRevision == s.Status.CurrentRevision
+}
+
+// DaemonSetRolledOut is true if the pods on all nodes are updated and available
+func DaemonSetRolledOut(d *appsv1.DaemonSet) bool {
+	if d.Generation > d.Status.ObservedGeneration {
+		return false
+	}
+	if d.Spec.UpdateStrategy.Type == appsv1.OnDeleteDaemonSetStrategyType {
+		return d.Status.NumberAvailable >= d.Status.DesiredNumberScheduled
+	}
+	return d.Status.UpdatedNumberScheduled >= d.Status.DesiredNumberScheduled &&
+		d.Status.NumberAvailable >= d.Status.DesiredNumberScheduled
+}
@@ -0,0 +1,93 @@
+package kubernetes_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+
+	"github.com/epinio/installer/internal/kubernetes"
+)
+
+func int32Ptr(i int32) *int32 { return &i }
+
+var _ = Describe("Rollout", func() {
+	Describe("DeploymentRolledOut", func() {
+		var d *appsv1.Deployment
+
+		BeforeEach(func() {
+			d = &appsv1.Deployment{}
+			d.Generation = 2
+			d.Spec.Replicas = int32Ptr(2)
+			d.Status = appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2}
+		})
+
+		It("is done when all replicas are updated and available", func() {
+			Expect(kubernetes.DeploymentRolledOut(d)).To(BeTrue())
+		})
+
+		It("waits for the controller to observe the new generation", func() {
+			d.Generation = 3
+			Expect(kubernetes.DeploymentRolledOut(d)).To(BeFalse())
+		})
+
+		It("waits for old replicas to terminate", func() {
+			d.Status.Replicas = 3
+			Expect(kubernetes.DeploymentRolledOut(d)).To(BeFalse())
+		})
+
+		It("waits for updated replicas to become available", func() {
+			d.Status.AvailableReplicas = 1
+			Expect(kubernetes.DeploymentRolledOut(d)).To(BeFalse())
+		})
+
+		It("fails when the progress deadline is exceeded", func() {
+			d.Name = "web"
+			d.Status.Conditions = []appsv1.DeploymentCondition{{Type: appsv1.DeploymentProgressing, Reason: "ProgressDeadlineExceeded"}}
+			_, err := kubernetes.DeploymentRolledOut(d)
+			Expect(err).To(MatchError("deployment 'web' exceeded its progress deadline"))
+		})
+	})
+
+	Describe("StatefulSetRolledOut", func() {
+		var s *appsv1.StatefulSet
+
+		BeforeEach(func() {
+			s = &appsv1.StatefulSet{}
+			s.Spec.Replicas = int32Ptr(3)
+			s.Status = appsv1.StatefulSetStatus{ReadyReplicas: 3, UpdatedReplicas: 3, CurrentRevision: "r1", UpdateRevision: "r1"}
+		})
+
+		It("is done when all replicas are ready with the current revision", func() {
+			Expect(kubernetes.StatefulSetRolledOut(s)).To(BeTrue())
+		})
+
+		It("waits for the update revision", func() {
+			s.Status.UpdateRevision = "r2"
+			Expect(kubernetes.StatefulSetRolledOut(s)).To(BeFalse())
+		})
+
+		It("only waits for replicas above the partition", func() {
+			s.Status.UpdateRevision = "r2"
+			s.Status.UpdatedReplicas = 1
+			s.Spec.UpdateStrategy.RollingUpdate = &appsv1.RollingUpdateStatefulSetStrategy{Partition: int32Ptr(2)}
+			Expect(kubernetes.StatefulSetRolledOut(s)).To(BeTrue())
+		})
+
+		It("waits for ready replicas", func() {
+			s.Status.ReadyReplicas = 2
+			Expect(kubernetes.StatefulSetRolledOut(s)).To(BeFalse())
+		})
+	})
+
+	Describe("DaemonSetRolledOut", func() {
+		It("is done when all scheduled pods are updated and available", func() {
+			d := &appsv1.DaemonSet{}
+			d.Status = appsv1.DaemonSetStatus{DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberAvailable: 3}
+			Expect(kubernetes.DaemonSetRolledOut(d)).To(BeTrue())
+
+			d.Status.UpdatedNumberScheduled = 2
+			Expect(kubernetes.DaemonSetRolledOut(d)).To(BeFalse())
+		})
+	})
+})
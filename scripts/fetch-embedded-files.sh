@@ -0,0 +1,45 @@
+#!/bin/bash
+# Fetches the charts and YAML files, which are compiled into the installer
+# binary, into assets/embedded-files. The release build runs this before
+# building, so 'embedded://' paths, like in assets/tests/test-manifest.yml,
+# work from any directory.
+#
+# Needs curl, git and helm.
+#
+# linkerd isn't embedded: 'linkerd install' generates the identity trust
+# anchor and issuer key, which would be shared by every installation. The
+# job in assets/installer/linkerd-job.yaml runs it in the cluster instead.
+
+set -euo pipefail
+
+CERT_MANAGER_VERSION=${CERT_MANAGER_VERSION:-v1.5.4}
+KUBED_VERSION=${KUBED_VERSION:-v0.12.0}
+TEKTON_VERSION=${TEKTON_VERSION:-v0.28.0}
+# the tekton tasks and pipeline for staging apps are taken from epinio
+EPINIO_VERSION=${EPINIO_VERSION:-v0.2.1}
+EPINIO_CHART_VERSION=${EPINIO_CHART_VERSION:-0.1.21}
+
+dir=$(cd "$(dirname "$0")/../assets/embedded-files" && pwd)
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+
+echo "fetching embedded files into $dir"
+
+helm pull cert-manager --repo https://charts.jetstack.io --version "$CERT_MANAGER_VERSION" -d "$dir"
+helm pull kubed --repo https://charts.appscode.com/stable/ --version "$KUBED_VERSION" -d "$dir"
+
+rm -rf "$dir/epinio-chart"
+helm pull epinio --repo https://epinio.github.io/helm-charts --version "$EPINIO_CHART_VERSION" --untar -d "$tmp/chart"
+mv "$tmp/chart/epinio" "$dir/epinio-chart"
+
+mkdir -p "$dir/tekton"
+curl -fsSL -o "$dir/tekton/pipeline-$TEKTON_VERSION.yaml" \
+  "https://storage.googleapis.com/tekton-releases/pipeline/previous/$TEKTON_VERSION/release.yaml"
+
+git clone --quiet --depth 1 --branch "$EPINIO_VERSION" https://github.com/epinio/epinio "$tmp/epinio"
+tasks="$tmp/epinio/assets/embedded-files/tekton"
+: > "$dir/tekton/epinio-pipeline.yaml"
+for f in aws-cli-0.2.yaml buildpacks-task.yaml stage-pipeline.yaml; do
+  echo "---" >> "$dir/tekton/epinio-pipeline.yaml"
+  cat "$tasks/$f" >> "$dir/tekton/epinio-pipeline.yaml"
+done